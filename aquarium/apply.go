@@ -0,0 +1,225 @@
+package aquarium
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Image is an image name together with the tagger it lives in
+type Image struct {
+	Name   string
+	Tagger Tagger
+}
+
+// ApplyOptions controls how Apply tags a set of images
+type ApplyOptions struct {
+	// Source is the image to tag, as understood by the Resolve of every tagger
+	Source string
+	// TagFormats are the tag templates rendered for every image name
+	TagFormats []string
+	// Labels are applied to the source before it is tagged, when not empty
+	Labels map[string]string
+	// Push pushes every applied tag to its registry
+	Push bool
+	// Force moves immutable tags even if they point at another image
+	Force bool
+	// ImmutableTags are the patterns of tags that must never be moved
+	ImmutableTags []string
+	// RequireSigned are the patterns of tags only applied when the
+	// Signature of the metadata is verified
+	RequireSigned []string
+	// BestEffort keeps the tags that were applied when others fail instead
+	// of rolling the whole run back, Apply returns them along with the error
+	BestEffort bool
+	// Concurrency is how many tag and push operations run at once
+	Concurrency int
+}
+
+// Result is what Apply did to a single image name
+type Result struct {
+	Image
+	// Source is the image that was tagged, the labeled copy when labels
+	// were applied
+	Source  string
+	Tags    []string
+	Digests []string
+	// Blocked are the references left out because they require a trusted
+	// signature the release doesn't have
+	Blocked []string
+}
+
+// imageJob is an image name being tagged in this run
+type imageJob struct {
+	name    string
+	backend Tagger
+	source  string
+
+	tags    []string
+	digests []string
+	blocked []string
+}
+
+// tagJob applies (and pushes) a single tag, independent of every other tag
+type tagJob struct {
+	image  *imageJob
+	ref    string
+	tagged bool
+	digest string
+}
+
+// Apply renders the tags of every image, applies them and pushes them when
+// asked to. Unless the run is best effort, the first failure stops it and
+// every tag applied and image labeled so far is rolled back. A best effort run
+// returns the results of what succeeded together with the errors.
+func Apply(ctx context.Context, tmplData *Metadata, images []Image, opts ApplyOptions) ([]Result, error) {
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultConcurrency
+	}
+
+	// everything that can fail before touching an image is done up front
+	jobs := make([]*imageJob, len(images))
+	var tags []*tagJob
+	for i, img := range images {
+		refs, err := RenderTags(img.Name, tmplData, opts.TagFormats)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs[i] = &imageJob{name: img.Name, backend: img.Tagger}
+		for _, ref := range refs {
+			tags = append(tags, &tagJob{image: jobs[i], ref: ref})
+		}
+	}
+
+	if len(opts.RequireSigned) > 0 {
+		var rendered []string
+		for _, job := range tags {
+			rendered = append(rendered, tagOf(job.ref))
+		}
+		blocked, err := unsignedTags(rendered, opts.RequireSigned, tmplData.Signature)
+		if err != nil {
+			return nil, err
+		}
+		var allowed []*tagJob
+		for _, job := range tags {
+			if contains(blocked, tagOf(job.ref)) {
+				job.image.blocked = append(job.image.blocked, job.ref)
+			} else {
+				allowed = append(allowed, job)
+			}
+		}
+		tags = allowed
+	}
+
+	undo := &journal{}
+	errs := runParallel(len(jobs), concurrency, func(i int) error {
+		img := jobs[i]
+		source, err := img.backend.Resolve(ctx, opts.Source, img.name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", img.name, err)
+		}
+		if len(opts.Labels) > 0 {
+			labeled, err := img.backend.Label(ctx, source, opts.Labels)
+			if err != nil {
+				return fmt.Errorf("labeling %s: %v", img.name, err)
+			}
+			undo.record("removing labeled "+labeled, func(ctx context.Context) error {
+				return img.backend.Unlabel(ctx, labeled)
+			})
+			source = labeled
+		}
+		img.source = source
+		return nil
+	})
+	if err := collectErrors(errs); err != nil {
+		return nil, undo.abort(ctx, err)
+	}
+
+	remote := newRegistryClient()
+	if !opts.Force {
+		immutable, err := newTagPatterns("immutable_tags", opts.ImmutableTags)
+		if err != nil {
+			return nil, undo.abort(ctx, err)
+		}
+		if err := checkImmutable(ctx, tags, immutable, remote, opts.Push, concurrency); err != nil {
+			return nil, undo.abort(ctx, err)
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs = runParallel(len(tags), concurrency, func(i int) error {
+		if runCtx.Err() != nil {
+			return nil
+		}
+		err := applyTag(runCtx, tags[i], undo, remote, opts.Push, opts.BestEffort)
+		if err != nil && !opts.BestEffort {
+			cancel()
+		}
+		return err
+	})
+	err := collectErrors(errs)
+	if err != nil && !opts.BestEffort {
+		return nil, undo.abort(ctx, err)
+	}
+
+	for _, job := range tags {
+		if !job.tagged {
+			continue
+		}
+		img := job.image
+		img.tags = append(img.tags, job.ref)
+		if job.digest != "" && !contains(img.digests, img.name+"@"+job.digest) {
+			img.digests = append(img.digests, img.name+"@"+job.digest)
+		}
+	}
+
+	results := make([]Result, len(jobs))
+	for i, img := range jobs {
+		results[i] = Result{
+			Image:   images[i],
+			Source:  img.source,
+			Tags:    img.tags,
+			Digests: img.digests,
+			Blocked: img.blocked,
+		}
+	}
+	return results, err
+}
+
+// tagOf is the tag part of a name:tag reference
+func tagOf(ref string) string {
+	return ref[strings.LastIndex(ref, ":")+1:]
+}
+
+// applyTag tags (and pushes) a single reference, recording how to undo it
+// unless the run is best effort
+func applyTag(ctx context.Context, job *tagJob, undo *journal, remote *registryClient, push, bestEffort bool) error {
+	backend := job.image.backend
+
+	var err error
+	if bestEffort {
+		err = backend.Tag(ctx, job.image.source, job.ref)
+	} else {
+		err = tagWithUndo(ctx, undo, backend, job.image.source, job.ref)
+	}
+	if err != nil {
+		return fmt.Errorf("tagging %s: %v", job.ref, err)
+	}
+	job.tagged = true
+
+	if push {
+		if bestEffort {
+			job.digest, err = backend.Push(ctx, job.ref)
+		} else {
+			job.digest, err = pushWithUndo(ctx, undo, remote, backend, job.ref)
+		}
+		if err != nil {
+			return fmt.Errorf("pushing %s: %v", job.ref, err)
+		}
+	}
+	return nil
+}
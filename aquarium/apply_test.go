@@ -0,0 +1,145 @@
+package aquarium
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTagger keeps tags in memory, tagging failTag fails
+type fakeTagger struct {
+	failTag string
+
+	mu      sync.Mutex
+	tags    map[string]string
+	labeled []string
+}
+
+func newFakeTagger(failTag string) *fakeTagger {
+	return &fakeTagger{failTag: failTag, tags: map[string]string{}}
+}
+
+func (f *fakeTagger) Resolve(ctx context.Context, source, name string) (string, error) {
+	return source, nil
+}
+
+func (f *fakeTagger) Inspect(ctx context.Context, source string) (*ImageInfo, error) {
+	return &ImageInfo{ID: source}, nil
+}
+
+func (f *fakeTagger) Label(ctx context.Context, source string, labels map[string]string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.labeled = append(f.labeled, "labeled-"+source)
+	return "labeled-" + source, nil
+}
+
+func (f *fakeTagger) Unlabel(ctx context.Context, labeled string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var kept []string
+	for _, l := range f.labeled {
+		if l != labeled {
+			kept = append(kept, l)
+		}
+	}
+	f.labeled = kept
+	return nil
+}
+
+func (f *fakeTagger) Tag(ctx context.Context, source, ref string) error {
+	if ref == f.failTag {
+		return errors.New("refused")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tags[ref] = source
+	return nil
+}
+
+func (f *fakeTagger) Current(ctx context.Context, ref string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tags[ref], nil
+}
+
+func (f *fakeTagger) Untag(ctx context.Context, ref string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tags, ref)
+	return nil
+}
+
+func (f *fakeTagger) Push(ctx context.Context, ref string) (string, error) {
+	return "", errors.New("fake tagger cannot push")
+}
+
+func TestApplyRollsBackLabels(t *testing.T) {
+	tagger := newFakeTagger("example.com/app:broken")
+	_, err := Apply(context.Background(), &Metadata{}, []Image{{Name: "example.com/app", Tagger: tagger}}, ApplyOptions{
+		Source:     "image",
+		TagFormats: []string{"1.0", "broken"},
+		Labels:     map[string]string{"a": "b"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("got %v, want the run rolled back", err)
+	}
+	if len(tagger.tags) != 0 || len(tagger.labeled) != 0 {
+		t.Errorf("left tags %v and labeled images %v behind", tagger.tags, tagger.labeled)
+	}
+}
+
+func TestApplyBestEffortKeepsLabels(t *testing.T) {
+	tagger := newFakeTagger("example.com/app:broken")
+	results, err := Apply(context.Background(), &Metadata{}, []Image{{Name: "example.com/app", Tagger: tagger}}, ApplyOptions{
+		Source:     "image",
+		TagFormats: []string{"1.0", "broken"},
+		Labels:     map[string]string{"a": "b"},
+		BestEffort: true,
+	})
+	if err == nil {
+		t.Fatal("the broken tag was applied")
+	}
+	if tagger.tags["example.com/app:1.0"] != "labeled-image" || len(tagger.labeled) != 1 {
+		t.Errorf("got tags %v and labeled images %v, want 1.0 on the labeled image", tagger.tags, tagger.labeled)
+	}
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Tags, []string{"example.com/app:1.0"}) || results[0].Source != "labeled-image" {
+		t.Errorf("got results %+v, want 1.0 reported on the labeled image", results)
+	}
+}
+
+func TestApplyBlocksUnsignedTags(t *testing.T) {
+	tagger := newFakeTagger("")
+	results, err := Apply(context.Background(), &Metadata{Signature: &GitSignature{Unsigned: true}}, []Image{{Name: "example.com/app", Tagger: tagger}}, ApplyOptions{
+		Source:        "image",
+		TagFormats:    []string{"1.1.0", "latest", "abc1234"},
+		RequireSigned: []string{`^\d+\.\d+\.\d+$`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := results[0].Blocked; len(got) != 1 || got[0] != "example.com/app:1.1.0" {
+		t.Errorf("blocked %v, want only the release tag", got)
+	}
+	if got := results[0].Tags; len(got) != 2 || tagger.tags["example.com/app:1.1.0"] != "" {
+		t.Errorf("applied %v, want latest and the commit tag", got)
+	}
+}
+
+func TestApplySignedTags(t *testing.T) {
+	tagger := newFakeTagger("")
+	results, err := Apply(context.Background(), &Metadata{Signature: &GitSignature{Verified: true}}, []Image{{Name: "example.com/app", Tagger: tagger}}, ApplyOptions{
+		Source:        "image",
+		TagFormats:    []string{"1.1.0", "latest"},
+		RequireSigned: []string{`^\d+\.\d+\.\d+$`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results[0].Blocked) > 0 || len(results[0].Tags) != 2 {
+		t.Errorf("applied %v and blocked %v, want every tag applied", results[0].Tags, results[0].Blocked)
+	}
+}
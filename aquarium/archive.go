@@ -0,0 +1,412 @@
+package aquarium
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	archiveManifestFile     = "manifest.json"
+	archiveRepositoriesFile = "repositories"
+)
+
+// archiveImage is an entry of the manifest.json `docker save` writes
+type archiveImage struct {
+	Config       string          `json:"Config"`
+	RepoTags     []string        `json:"RepoTags"`
+	Layers       []string        `json:"Layers"`
+	Parent       string          `json:"Parent,omitempty"`
+	LayerSources json.RawMessage `json:"LayerSources,omitempty"`
+}
+
+// archiveBackend retags a `docker save` tarball by rewriting its manifest.json
+// and repositories files, the new tarball is written when the run is done
+type archiveBackend struct {
+	input  string
+	output string
+
+	gzipped      bool
+	images       []archiveImage
+	repositories map[string]map[string]string
+	// files added to the archive, relabeled image configs
+	extra map[string][]byte
+	dirty bool
+
+	mu sync.Mutex
+}
+
+// NewArchiveTagger reads the `docker save` tarball input, the retagged tarball
+// is written to output (input when empty) once the tagger is closed
+func NewArchiveTagger(input, output string) (Tagger, error) {
+	if input == "" {
+		return nil, errors.New("the archive backend needs a docker save tarball, set archive or -archive")
+	}
+	if output == "" {
+		output = input
+	}
+
+	a := &archiveBackend{
+		input:  input,
+		output: output,
+		extra:  map[string][]byte{},
+	}
+
+	manifest, err := a.readFile(archiveManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(manifest, &a.images); err != nil {
+		return nil, fmt.Errorf("reading %s of %s: %v", archiveManifestFile, input, err)
+	}
+
+	repositories, err := a.readFile(archiveRepositoriesFile)
+	if err == nil {
+		if err := json.Unmarshal(repositories, &a.repositories); err != nil {
+			return nil, fmt.Errorf("reading %s of %s: %v", archiveRepositoriesFile, input, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open returns a tar reader for the input, transparently handling gzip
+func (a *archiveBackend) open() (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(a.input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := bufio.NewReader(f)
+	magic, err := buf.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		a.gzipped = true
+		return tar.NewReader(gz), f, nil
+	}
+	return tar.NewReader(buf), f, nil
+}
+
+// readFile returns a single file of the archive, os.ErrNotExist when missing
+func (a *archiveBackend) readFile(name string) ([]byte, error) {
+	if data, ok := a.extra[name]; ok {
+		return data, nil
+	}
+
+	tr, closer, err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, os.ErrNotExist
+		} else if err != nil {
+			return nil, err
+		}
+		if path.Clean(hdr.Name) == name {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+// find returns the image source names, matched on image id (with or without
+// the sha256: prefix, possibly shortened) or one of its RepoTags
+func (a *archiveBackend) find(source string) (*archiveImage, error) {
+	id := strings.TrimPrefix(source, "sha256:")
+	for i, img := range a.images {
+		configID := strings.TrimSuffix(path.Base(img.Config), ".json")
+		if img.Config == source || (len(id) >= 12 && strings.HasPrefix(configID, id)) || contains(img.RepoTags, source) {
+			return &a.images[i], nil
+		}
+	}
+	return nil, fmt.Errorf("image %s not found in %s", source, a.input)
+}
+
+// Resolve returns the config file of the image, which is what identifies it
+// inside the archive
+func (a *archiveBackend) Resolve(ctx context.Context, source, name string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	img, err := a.find(source)
+	if err != nil {
+		return "", err
+	}
+	return img.Config, nil
+}
+
+func (a *archiveBackend) Inspect(ctx context.Context, source string) (*ImageInfo, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	img, err := a.find(source)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageInfo{ID: "sha256:" + strings.TrimSuffix(path.Base(img.Config), ".json")}, nil
+}
+
+// Label adds a copy of the image with the labels merged into its config, it
+// shares every layer with the original
+func (a *archiveBackend) Label(ctx context.Context, source string, labels map[string]string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	img, err := a.find(source)
+	if err != nil {
+		return "", err
+	}
+	configData, err := a.readFile(img.Config)
+	if err != nil {
+		return "", err
+	}
+	config, err := withLabels(configData, labels)
+	if err != nil {
+		return "", fmt.Errorf("labeling %s: %v", source, err)
+	}
+
+	configFile := path.Join(path.Dir(img.Config), strings.TrimPrefix(digestOf(config), "sha256:")+".json")
+	if _, err := a.find(configFile); err != nil {
+		labeled := *img
+		labeled.Config = configFile
+		labeled.RepoTags = nil
+		a.images = append(a.images, labeled)
+		a.extra[configFile] = config
+		a.dirty = true
+	}
+	return configFile, nil
+}
+
+// Unlabel drops the image copy Label added, nothing when it existed before
+func (a *archiveBackend) Unlabel(ctx context.Context, labeled string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.extra[labeled]; !ok {
+		return nil
+	}
+	var kept []archiveImage
+	for _, img := range a.images {
+		if img.Config != labeled {
+			kept = append(kept, img)
+		}
+	}
+	a.images = kept
+	delete(a.extra, labeled)
+	a.dirty = true
+	return nil
+}
+
+// Tag adds ref to the RepoTags of source, removing it from any other image
+func (a *archiveBackend) Tag(ctx context.Context, source, ref string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := ParseReference(ref); err != nil {
+		return err
+	}
+	img, err := a.find(source)
+	if err != nil {
+		return err
+	}
+
+	a.untag(ref)
+	img.RepoTags = append(img.RepoTags, ref)
+
+	if a.repositories != nil && len(img.Layers) > 0 {
+		// the legacy repositories file points tags at the id of the top layer
+		topLayer := path.Dir(img.Layers[len(img.Layers)-1])
+		if topLayer != "." && !strings.Contains(topLayer, "/") {
+			i := strings.LastIndex(ref, ":")
+			repo, tag := ref[:i], ref[i+1:]
+			if a.repositories[repo] == nil {
+				a.repositories[repo] = map[string]string{}
+			}
+			a.repositories[repo][tag] = topLayer
+		}
+	}
+
+	a.dirty = true
+	return nil
+}
+
+// Current returns the config file of the image carrying ref
+func (a *archiveBackend) Current(ctx context.Context, ref string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, img := range a.images {
+		if contains(img.RepoTags, ref) {
+			return img.Config, nil
+		}
+	}
+	return "", nil
+}
+
+func (a *archiveBackend) Untag(ctx context.Context, ref string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.untag(ref)
+	a.dirty = true
+	return nil
+}
+
+// untag removes ref from every image and the repositories file
+func (a *archiveBackend) untag(ref string) {
+	for i := range a.images {
+		var tags []string
+		for _, t := range a.images[i].RepoTags {
+			if t != ref {
+				tags = append(tags, t)
+			}
+		}
+		a.images[i].RepoTags = tags
+	}
+
+	if i := strings.LastIndex(ref, ":"); i > 0 && a.repositories != nil {
+		repo, tag := ref[:i], ref[i+1:]
+		delete(a.repositories[repo], tag)
+		if len(a.repositories[repo]) == 0 {
+			delete(a.repositories, repo)
+		}
+	}
+}
+
+func (a *archiveBackend) Push(ctx context.Context, ref string) (string, error) {
+	return "", errors.New("the archive backend cannot push, load the tarball into a daemon first")
+}
+
+// Close writes the retagged tarball, replacing the output through a rename
+// so an interrupted run never leaves a truncated archive behind
+func (a *archiveBackend) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.dirty {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(a.output), ".aquarium-archive-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := a.writeTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// TempFile creates the file 0600, keep the mode of the archive replaced
+	info, err := os.Stat(a.output)
+	if os.IsNotExist(err) {
+		info, err = os.Stat(a.input)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.output)
+}
+
+func (a *archiveBackend) writeTo(w io.Writer) error {
+	var gz *gzip.Writer
+	if a.gzipped {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+
+	tr, closer, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if name := path.Clean(hdr.Name); name == archiveManifestFile || name == archiveRepositoriesFile {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(a.extra))
+	for name := range a.extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeTarFile(tw, name, a.extra[name]); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := json.Marshal(a.images)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, archiveManifestFile, manifest); err != nil {
+		return err
+	}
+
+	if a.repositories != nil {
+		repositories, err := json.Marshal(a.repositories)
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, archiveRepositoriesFile, repositories); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,171 @@
+package aquarium
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	archiveConfigID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	archiveLayerID  = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+// writeSaveTarball writes what `docker save example.com/app:old` looks like,
+// gzipped and with the given mode
+func writeSaveTarball(t *testing.T, file string, mode os.FileMode) {
+	manifest, _ := json.Marshal([]archiveImage{{
+		Config:   archiveConfigID + ".json",
+		RepoTags: []string{"example.com/app:old"},
+		Layers:   []string{archiveLayerID + "/layer.tar"},
+	}})
+	repositories, _ := json.Marshal(map[string]map[string]string{"example.com/app": {"old": archiveLayerID}})
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct{ name, data string }{
+		{archiveConfigID + ".json", `{"architecture":"amd64","config":{"Labels":{"keep":"me"}},"rootfs":{"type":"layers"}}`},
+		{archiveLayerID + "/layer.tar", "layer"},
+		{archiveManifestFile, string(manifest)},
+		{archiveRepositoriesFile, string(repositories)},
+	} {
+		if err := writeTarFile(tw, f.name, []byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "app.tar.gz")
+	writeSaveTarball(t, file, 0640)
+	ctx := context.Background()
+
+	tagger, err := NewArchiveTagger(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"sha256:" + archiveConfigID, archiveConfigID[:12], "example.com/app:old"} {
+		if got, err := tagger.Resolve(ctx, source, "example.com/app"); err != nil || got != archiveConfigID+".json" {
+			t.Errorf("Resolve(%s) = %q, %v, want the config file", source, got, err)
+		}
+	}
+	if _, err := tagger.Resolve(ctx, "example.com/app:missing", "example.com/app"); err == nil {
+		t.Error("resolved an image the archive doesn't have")
+	}
+
+	labeled, err := tagger.Label(ctx, archiveConfigID+".json", map[string]string{"version": "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tagger.Tag(ctx, labeled, "example.com/app:1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tagger.Tag(ctx, labeled, "example.com/app:old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tagger.Tag(ctx, archiveConfigID+".json", "example.com/app:plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tagger.Untag(ctx, "example.com/app:plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tagger.(*archiveBackend).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("the archive was rewritten with mode %v, want 0640", info.Mode().Perm())
+	}
+
+	reread, err := NewArchiveTagger(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := reread.(*archiveBackend)
+	if !a.gzipped {
+		t.Error("the rewritten archive is no longer gzipped")
+	}
+	for ref, want := range map[string]string{
+		"example.com/app:1.0":   labeled,
+		"example.com/app:old":   labeled,
+		"example.com/app:plain": "",
+	} {
+		if got, err := reread.Current(ctx, ref); err != nil || got != want {
+			t.Errorf("%s points at %q (%v), want %q", ref, got, err, want)
+		}
+	}
+	if want := map[string]map[string]string{"example.com/app": {"1.0": archiveLayerID, "old": archiveLayerID}}; !reflect.DeepEqual(a.repositories, want) {
+		t.Errorf("repositories = %v, want %v", a.repositories, want)
+	}
+
+	config, err := a.readFile(labeled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), `"Labels":{"keep":"me","version":"1.0"}`) {
+		t.Errorf("labeled config is %s, want both labels", config)
+	}
+	if layer, err := a.readFile(archiveLayerID + "/layer.tar"); err != nil || string(layer) != "layer" {
+		t.Errorf("layer is %q (%v), want it copied as is", layer, err)
+	}
+}
+
+func TestArchiveUnlabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "app.tar.gz")
+	writeSaveTarball(t, file, 0644)
+	ctx := context.Background()
+
+	tagger, err := NewArchiveTagger(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := tagger.(*archiveBackend)
+	labeled, err := a.Label(ctx, "example.com/app:old", map[string]string{"version": "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Unlabel(ctx, labeled); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Unlabel(ctx, archiveConfigID+".json"); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.images) != 1 || a.images[0].Config != archiveConfigID+".json" || len(a.extra) != 0 {
+		t.Errorf("unlabeling left %+v, want only the original image", a.images)
+	}
+}
@@ -0,0 +1,120 @@
+package aquarium
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// dockerHubAuthKey is the key docker login stores docker hub credentials under
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// dockerConfigFile is the subset of ~/.docker/config.json aquarium understands
+type dockerConfigFile struct {
+	Auths       map[string]types.AuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+// loadCredentials looks up the credentials `docker login` stored for host, an
+// empty AuthConfig means anonymous access
+func loadCredentials(host string) (types.AuthConfig, error) {
+	authKey := host
+	if host == "docker.io" {
+		authKey = dockerHubAuthKey
+	}
+
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return types.AuthConfig{}, nil
+	} else if err != nil {
+		return types.AuthConfig{}, err
+	}
+
+	config := dockerConfigFile{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return types.AuthConfig{}, err
+	}
+
+	if helper, ok := config.CredHelpers[host]; ok {
+		return credentialHelper(helper, authKey)
+	}
+	if config.CredsStore != "" {
+		return credentialHelper(config.CredsStore, authKey)
+	}
+
+	for key, auth := range config.Auths {
+		if authHost(key) != authHost(authKey) {
+			continue
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return types.AuthConfig{}, err
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) == 2 {
+				auth.Username, auth.Password = parts[0], parts[1]
+			}
+		}
+		auth.ServerAddress = authKey
+		return auth, nil
+	}
+	return types.AuthConfig{}, nil
+}
+
+// credentialHelper runs docker-credential-<helper> the way the docker cli does
+func credentialHelper(helper, serverURL string) (types.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// helpers exit non-zero when they have no credentials for the server
+		return types.AuthConfig{}, nil
+	}
+
+	creds := struct {
+		Username string
+		Secret   string
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return types.AuthConfig{}, err
+	}
+
+	auth := types.AuthConfig{ServerAddress: serverURL}
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+	return auth, nil
+}
+
+// encodeAuth encodes credentials for the X-Registry-Auth header
+func encodeAuth(auth types.AuthConfig) (string, error) {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+func authHost(key string) string {
+	if i := strings.Index(key, "://"); i >= 0 {
+		key = key[i+3:]
+	}
+	return strings.SplitN(key, "/", 2)[0]
+}
@@ -0,0 +1,162 @@
+package aquarium
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	yaml "gopkg.in/yaml.v1"
+)
+
+// Config is the content of .aquarium.yml
+type Config struct {
+	TagFormat   []string `yaml:"tag_format"`
+	LabelFormat []string `yaml:"label_format"`
+	ImageNames  []string `yaml:"image_names"`
+	OCILabels   bool     `yaml:"oci_labels"`
+	ApplyLabels bool     `yaml:"apply_labels"`
+	// BuildArgs are key=value templates handed to docker build by export
+	BuildArgs []string `yaml:"build_args"`
+
+	Backend       string            `yaml:"backend"`
+	ImageBackends map[string]string `yaml:"image_backends"`
+	OCILayout     string            `yaml:"oci_layout"`
+	Archive       string            `yaml:"archive"`
+	ArchiveOutput string            `yaml:"archive_output"`
+
+	Promote PromoteConfig `yaml:"promote"`
+
+	// FetchMissing deepens shallow clones and fetches tags when needed
+	FetchMissing bool `yaml:"fetch_missing"`
+
+	// Timezone is the IANA name of the zone times are rendered in, UTC by default
+	Timezone string `yaml:"timezone"`
+	// CalVer is the calendar versioning scheme of the tags, YYYY.MM.MICRO
+	CalVer string `yaml:"calver"`
+	// TagPattern parses tags following neither semver nor CalVer
+	TagPattern TagPatternConfig `yaml:"tag_pattern"`
+	// Reproducible takes the commit date as build time unless
+	// SOURCE_DATE_EPOCH is set
+	Reproducible bool `yaml:"reproducible"`
+
+	Concurrency int  `yaml:"concurrency"`
+	BestEffort  bool `yaml:"best_effort"`
+
+	// ImmutableTags are patterns of tags that must never be moved once they exist
+	ImmutableTags []string `yaml:"immutable_tags"`
+
+	Signatures SignatureConfig `yaml:"signatures"`
+
+	// Vars are user defined variables, available as .Vars.name in templates
+	Vars map[string]Var `yaml:"vars"`
+}
+
+// PromoteConfig is the promote section of .aquarium.yml
+type PromoteConfig struct {
+	// From is the staging repository CI pushes every build to
+	From string `yaml:"from"`
+	// To is the production repository releases are copied to
+	To string `yaml:"to"`
+	// CommitTag is the tag the staging image was pushed with
+	CommitTag string `yaml:"commit_tag"`
+	// TagFormat are the release tags, the top level tag_format when empty
+	TagFormat []string `yaml:"tag_format"`
+}
+
+// SignatureConfig is the signatures section of .aquarium.yml, it decides
+// which git signatures are trusted and which tags need one
+type SignatureConfig struct {
+	// AllowedSigners is an ssh allowed signers file, the format of
+	// gpg.ssh.allowedSignersFile
+	AllowedSigners string `yaml:"allowed_signers"`
+	// GPGKeys are the fingerprints of the trusted gpg keys, the keys
+	// themselves have to be in the keyring
+	GPGKeys []string `yaml:"gpg_keys"`
+	// RequireSigned are patterns of tags only applied when the release tag
+	// or the commit carries a trusted signature
+	RequireSigned []string `yaml:"require_signed"`
+}
+
+// Enabled reports whether any signature verification is configured
+func (s *SignatureConfig) Enabled() bool {
+	return s.AllowedSigners != "" || len(s.GPGKeys) > 0 || len(s.RequireSigned) > 0
+}
+
+// TagPatternConfig is the tag_pattern section of .aquarium.yml
+type TagPatternConfig struct {
+	// Regexp matches the whole tag name, its named groups become .Tag.Fields
+	Regexp string `yaml:"regexp"`
+	// Order are the groups versions are compared by, all of them in the
+	// order they appear when empty
+	Order []string `yaml:"order"`
+}
+
+// Compile compiles the tag pattern, nil when none is configured
+func (p *TagPatternConfig) Compile() (*TagPattern, error) {
+	if p.Regexp == "" {
+		return nil, nil
+	}
+	return NewTagPattern(p.Regexp, p.Order)
+}
+
+// DefaultCommitTag is the tag staging images are expected to carry when
+// promote.commit_tag is not set
+const DefaultCommitTag = "{{ .Commit.ShortHash }}"
+
+// ParseConfig reads the YAML of an .aquarium.yml file and interpolates the
+// environment variables it references
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.Interpolate(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if _, err := config.Location(); err != nil {
+		return nil, err
+	}
+	if _, err := config.CalVerScheme(); err != nil {
+		return nil, err
+	}
+	if _, err := config.TagPattern.Compile(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Location loads the configured time zone, UTC when none is set
+func (c *Config) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %v", err)
+	}
+	return loc, nil
+}
+
+// BackendFor decides which backend handles an image name: an entry in
+// image_backends wins over override (the -backend flag), which wins over the
+// config default
+func (c *Config) BackendFor(name, override string) string {
+	if b, ok := c.ImageBackends[name]; ok && b != "" {
+		return b
+	}
+	if override != "" {
+		return override
+	}
+	if c.Backend != "" {
+		return c.Backend
+	}
+	return BackendDocker
+}
+
+// CalVerScheme compiles the calver setting, nil when tags use semver
+func (c *Config) CalVerScheme() (*CalVerScheme, error) {
+	if c.CalVer == "" {
+		return nil, nil
+	}
+	return ParseCalVerScheme(c.CalVer)
+}
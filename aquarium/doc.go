@@ -0,0 +1,15 @@
+// Package aquarium computes tags and labels for container images from git
+// metadata and applies them, it is what the aquarium command is built on.
+//
+// A Collector reads the Metadata of a repository, Render, RenderTags and
+// Labels execute templates against it and Apply tags images through any
+// Tagger, the docker, podman, registry, OCI layout and archive taggers come
+// with the package:
+//
+//	data, err := (&aquarium.Collector{Dir: "."}).Collect(ctx)
+//	tagger, err := aquarium.NewDockerTagger(aquarium.DockerOptions{})
+//	results, err := aquarium.Apply(ctx, data, []aquarium.Image{{Name: "org/app", Tagger: tagger}}, aquarium.ApplyOptions{
+//		Source:     imageID,
+//		TagFormats: []string{"{{ .Commit.ShortHash }}"},
+//	})
+package aquarium
@@ -0,0 +1,311 @@
+package aquarium
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// dockerBackend talks to anything speaking the Docker Engine API, which
+// includes podman's docker compatible socket
+type dockerBackend struct {
+	client *client.Client
+}
+
+// DockerOptions configures the connection to the daemon, anything left empty
+// falls back to the DOCKER_* environment variables the docker cli uses
+type DockerOptions struct {
+	Host      string
+	TLSCACert string
+	TLSCert   string
+	TLSKey    string
+	TLSVerify bool
+}
+
+// NewDockerTagger connects to the docker daemon described by opts
+func NewDockerTagger(opts DockerOptions) (Tagger, error) {
+	host := firstNonEmpty(opts.Host, os.Getenv("DOCKER_HOST"), client.DefaultDockerHost)
+
+	var caFile, certFile, keyFile string
+	if certPath := os.Getenv("DOCKER_CERT_PATH"); certPath != "" {
+		caFile = filepath.Join(certPath, "ca.pem")
+		certFile = filepath.Join(certPath, "cert.pem")
+		keyFile = filepath.Join(certPath, "key.pem")
+	}
+	caFile = firstNonEmpty(opts.TLSCACert, caFile)
+	certFile = firstNonEmpty(opts.TLSCert, certFile)
+	keyFile = firstNonEmpty(opts.TLSKey, keyFile)
+	verify := opts.TLSVerify || os.Getenv("DOCKER_TLS_VERIFY") != ""
+
+	var httpClient *http.Client
+	if caFile != "" || certFile != "" || verify {
+		tlsc, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             caFile,
+			CertFile:           certFile,
+			KeyFile:            keyFile,
+			InsecureSkipVerify: !verify,
+		})
+		if err != nil {
+			return nil, err
+		}
+		httpClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsc},
+		}
+	}
+
+	docker, err := newNegotiatedClient(host, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &dockerBackend{client: docker}, nil
+}
+
+// newNegotiatedClient connects to host speaking the API version the daemon
+// reports, DOCKER_API_VERSION still pins it when set. Newer daemons refuse
+// older versions (Docker Engine 29 requires 1.44), the endpoints aquarium
+// calls are unchanged since 1.24 and inspectImage doesn't rely on the vendored
+// types for the responses that did change
+func newNegotiatedClient(host string, httpClient *http.Client) (*client.Client, error) {
+	if pinned := os.Getenv("DOCKER_API_VERSION"); pinned != "" {
+		return client.NewClient(host, pinned, httpClient, nil)
+	}
+
+	// without a version the client talks to the unversioned endpoints
+	cli, err := client.NewClient(host, "", httpClient, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ping, err := cli.Ping(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the daemon at %s: %v", host, err)
+	}
+	if ping.APIVersion != "" {
+		cli.UpdateClientVersion(ping.APIVersion)
+	}
+	return cli, nil
+}
+
+// NewPodmanTagger connects to the podman API service, CONTAINER_HOST is
+// honored the same way the podman remote client does
+func NewPodmanTagger() (Tagger, error) {
+	host := os.Getenv("CONTAINER_HOST")
+	if host == "" {
+		host = "unix:///run/podman/podman.sock"
+		if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" && os.Getuid() != 0 {
+			host = "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock")
+		}
+	}
+
+	podman, err := newNegotiatedClient(host, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &dockerBackend{client: podman}, nil
+}
+
+// Resolve has nothing to do, image ids and references are understood as is
+func (d *dockerBackend) Resolve(ctx context.Context, source, name string) (string, error) {
+	return source, nil
+}
+
+// engineImage is the part of an image inspect response aquarium reads
+type engineImage struct {
+	ID          string `json:"Id"`
+	RepoTags    []string
+	RepoDigests []string
+}
+
+// inspectImage decodes the raw inspect response itself, what newer daemons
+// return doesn't always fit the vendored types.ImageInspect
+func (d *dockerBackend) inspectImage(ctx context.Context, ref string) (*engineImage, error) {
+	_, raw, err := d.client.ImageInspectWithRaw(ctx, ref)
+	if raw == nil {
+		return nil, err
+	}
+	image := &engineImage{}
+	if err := json.Unmarshal(raw, image); err != nil {
+		return nil, fmt.Errorf("inspecting %s: %v", ref, err)
+	}
+	return image, nil
+}
+
+func (d *dockerBackend) Inspect(ctx context.Context, source string) (*ImageInfo, error) {
+	inspect, err := d.inspectImage(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageInfo{
+		ID:      inspect.ID,
+		Digests: inspect.RepoDigests,
+	}, nil
+}
+
+// Label builds a single layer-less image FROM source with the labels applied,
+// the daemon has no way of changing the config of an existing image
+func (d *dockerBackend) Label(ctx context.Context, source string, labels map[string]string) (string, error) {
+	dockerfile := []byte(fmt.Sprintf("FROM %s\n", source))
+
+	buildContext := new(bytes.Buffer)
+	tw := tar.NewWriter(buildContext)
+	if err := tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len(dockerfile))}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(dockerfile); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+
+	resp, err := d.client.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Dockerfile: "Dockerfile",
+		Labels:     labels,
+		Remove:     true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var id string
+	err = readJSONMessages(resp.Body, func(msg jsonMessage) {
+		if msg.Aux != nil && msg.Aux.ID != "" {
+			id = msg.Aux.ID
+		}
+		if strings.HasPrefix(msg.Stream, "Successfully built ") && id == "" {
+			id = strings.TrimSpace(strings.TrimPrefix(msg.Stream, "Successfully built "))
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", errors.New("build of labeled image did not report an image id")
+	}
+	return id, nil
+}
+
+// Unlabel removes the labeled image, unless something tagged it meanwhile
+func (d *dockerBackend) Unlabel(ctx context.Context, labeled string) error {
+	inspect, err := d.inspectImage(ctx, labeled)
+	if client.IsErrImageNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(inspect.RepoTags) > 0 {
+		return nil
+	}
+	_, err = d.client.ImageRemove(ctx, labeled, types.ImageRemoveOptions{PruneChildren: true})
+	return err
+}
+
+func (d *dockerBackend) Tag(ctx context.Context, source, ref string) error {
+	return d.client.ImageTag(ctx, source, ref)
+}
+
+// Current returns the id of the image ref points at, empty when there is none
+func (d *dockerBackend) Current(ctx context.Context, ref string) (string, error) {
+	inspect, err := d.inspectImage(ctx, ref)
+	if client.IsErrImageNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return inspect.ID, nil
+}
+
+// Untag removes ref, unless it is the last reference keeping the image alive,
+// removing it would delete the image itself
+func (d *dockerBackend) Untag(ctx context.Context, ref string) error {
+	inspect, err := d.inspectImage(ctx, ref)
+	if client.IsErrImageNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(inspect.RepoTags) <= 1 {
+		return fmt.Errorf("%s is the only reference to %s, leaving it in place", ref, inspect.ID)
+	}
+
+	_, err = d.client.ImageRemove(ctx, ref, types.ImageRemoveOptions{})
+	return err
+}
+
+func (d *dockerBackend) Push(ctx context.Context, ref string) (string, error) {
+	target, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	creds, err := loadCredentials(target.Host)
+	if err != nil {
+		return "", err
+	}
+	registryAuth, err := encodeAuth(creds)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := d.client.ImagePush(ctx, ref, types.ImagePushOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var digest string
+	err = readJSONMessages(body, func(msg jsonMessage) {
+		if msg.Aux != nil && msg.Aux.Digest != "" {
+			digest = msg.Aux.Digest
+		}
+	})
+	return digest, err
+}
+
+// jsonMessage is a single line of the progress stream build and push return
+type jsonMessage struct {
+	Stream      string `json:"stream"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux *struct {
+		ID     string `json:"ID"`
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+func readJSONMessages(r io.Reader, fn func(jsonMessage)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if msg.ErrorDetail != nil {
+			return errors.New(msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		fn(msg)
+	}
+}
@@ -0,0 +1,137 @@
+package aquarium
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeEngine speaks the few Docker Engine API endpoints aquarium calls, and
+// like Docker Engine 29 only at its own version
+type fakeEngine struct {
+	version string
+
+	mu     sync.Mutex
+	images map[string]*engineImage
+}
+
+func newFakeEngine(version string) (*fakeEngine, *httptest.Server) {
+	e := &fakeEngine{version: version, images: map[string]*engineImage{}}
+	return e, httptest.NewServer(e)
+}
+
+func (e *fakeEngine) addImage(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.images[id] = &engineImage{ID: id}
+}
+
+// lookup finds an image by id or one of its tags
+func (e *fakeEngine) lookup(name string) *engineImage {
+	for id, image := range e.images {
+		if id == name {
+			return image
+		}
+		for _, tag := range image.RepoTags {
+			if tag == name {
+				return image
+			}
+		}
+	}
+	return nil
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w.Header().Set("API-Version", e.version)
+	if r.URL.Path == "/_ping" {
+		w.Write([]byte("OK"))
+		return
+	}
+	prefix := "/v" + e.version + "/images/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, `{"message":"client version is too old"}`, http.StatusBadRequest)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(path, "/json"):
+		image := e.lookup(strings.TrimSuffix(path, "/json"))
+		if image == nil {
+			http.Error(w, `{"message":"No such image"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(image)
+	case r.Method == "POST" && strings.HasSuffix(path, "/tag"):
+		image := e.lookup(strings.TrimSuffix(path, "/tag"))
+		if image == nil {
+			http.Error(w, `{"message":"No such image"}`, http.StatusNotFound)
+			return
+		}
+		image.RepoTags = append(image.RepoTags, r.URL.Query().Get("repo")+":"+r.URL.Query().Get("tag"))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "DELETE":
+		image := e.lookup(path)
+		if image == nil {
+			http.Error(w, `{"message":"No such image"}`, http.StatusNotFound)
+			return
+		}
+		var kept []string
+		for _, tag := range image.RepoTags {
+			if tag != path {
+				kept = append(kept, tag)
+			}
+		}
+		image.RepoTags = kept
+		json.NewEncoder(w).Encode([]map[string]string{{"Untagged": path}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDockerSpeaksDaemonVersion(t *testing.T) {
+	engine, server := newFakeEngine("1.44")
+	defer server.Close()
+	engine.addImage("sha256:abc")
+
+	cli, err := newNegotiatedClient("tcp://"+strings.TrimPrefix(server.URL, "http://"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dockerBackend{client: cli}
+	ctx := context.Background()
+
+	if err := d.Tag(ctx, "sha256:abc", "example.com/app:1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := d.Current(ctx, "example.com/app:1.0"); err != nil || id != "sha256:abc" {
+		t.Fatalf("1.0 points at %q (%v), want sha256:abc", id, err)
+	}
+	if id, err := d.Current(ctx, "example.com/app:2.0"); err != nil || id != "" {
+		t.Errorf("2.0 points at %q (%v), want nothing", id, err)
+	}
+
+	if err := d.Untag(ctx, "example.com/app:1.0"); err == nil {
+		t.Error("removed the only reference to the image")
+	}
+	if err := d.Tag(ctx, "sha256:abc", "example.com/app:latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Untag(ctx, "example.com/app:1.0"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := d.Inspect(ctx, "sha256:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := d.Current(ctx, "example.com/app:1.0"); id != "" || info.ID != "sha256:abc" {
+		t.Errorf("1.0 still points at %q after untagging", id)
+	}
+}
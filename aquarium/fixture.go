@@ -0,0 +1,153 @@
+package aquarium
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v1"
+)
+
+// ReadMetadata loads Metadata from a JSON or YAML file, the keys are the
+// field names as used in templates (Tag, Commit.ShortHash, ...) so a fixture
+// can stand in for a real repository
+func ReadMetadata(path string) (*Metadata, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if ext := filepath.Ext(path); ext == ".yml" || ext == ".yaml" {
+		doc, err := decodeYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		// go through JSON, which matches keys to field names regardless of case
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+	}
+
+	m := &Metadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// decodeYAML decodes a YAML document into the maps, lists and strings
+// encoding/json would produce, with every scalar kept as written: to yaml a
+// version like 1.10 is the number 1.1 and 0123 is octal. Booleans stay
+// booleans, they are the only values in Metadata that aren't strings.
+func decodeYAML(data []byte) (interface{}, error) {
+	// the first pass tells the shape of the document, the second decodes it
+	// into strings of that shape, which yaml fills with the text as written
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	text := reflect.New(textType(doc))
+	if err := yaml.Unmarshal(data, text.Interface()); err != nil {
+		return nil, err
+	}
+	return textValue(doc, text.Elem()), nil
+}
+
+var (
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	stringType    = reflect.TypeOf("")
+)
+
+// yamlKeys returns the keys of a yaml map, sorted by their string form
+func yamlKeys(m map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+	return keys
+}
+
+// textType is the type of v with strings for its scalars: a struct with a
+// field per key for a map, []string for a list of scalars. Whatever can't be
+// expressed that way, like keys yaml can't take as a field tag, stays an
+// interface{}.
+func textType(v interface{}) reflect.Type {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		seen := map[string]bool{}
+		fields := make([]reflect.StructField, 0, len(v))
+		for i, key := range yamlKeys(v) {
+			name := fmt.Sprint(key)
+			if name == "" || name == "-" || seen[name] || strings.ContainsAny(name, ",\"\\`") {
+				return interfaceType
+			}
+			seen[name] = true
+			fields = append(fields, reflect.StructField{
+				Name: fmt.Sprintf("F%d", i),
+				Type: textType(v[key]),
+				Tag:  reflect.StructTag(`yaml:"` + name + `"`),
+			})
+		}
+		return reflect.StructOf(fields)
+	case []interface{}:
+		for _, e := range v {
+			switch e.(type) {
+			case map[interface{}]interface{}, []interface{}, bool:
+				return interfaceType
+			}
+		}
+		return reflect.SliceOf(stringType)
+	case bool, nil:
+		return interfaceType
+	}
+	return stringType
+}
+
+// textValue turns v, decoded into textType(doc), into JSON compatible values
+func textValue(doc interface{}, v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		m := doc.(map[interface{}]interface{})
+		out := make(map[string]interface{}, len(m))
+		for i, key := range yamlKeys(m) {
+			out[fmt.Sprint(key)] = textValue(m[key], v.Field(i))
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = v.Index(i).String()
+		}
+		return out
+	case reflect.String:
+		return v.String()
+	}
+	return jsonCompatible(v.Interface())
+}
+
+// jsonCompatible turns the map[interface{}]interface{} values yaml produces
+// into maps encoding/json can marshal, numbers become strings as every
+// numeric looking field (Major, ShortHash, ...) is a string
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int, int64, uint64, float64:
+		return fmt.Sprint(v)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonCompatible(v[i])
+		}
+		return v
+	}
+	return v
+}
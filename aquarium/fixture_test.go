@@ -0,0 +1,79 @@
+package aquarium
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadMetadataKeepsScalarsAsWritten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixture := filepath.Join(dir, "release.yml")
+	err = ioutil.WriteFile(fixture, []byte(`
+Tag:
+  Raw: 1.10
+  Major: 1
+  Minor: 10
+  Month: 03
+  Latest: true
+  Fields: {build: 0042, stage: 1e3}
+Commit:
+  ShortHash: 0123456
+  Tags: [1.10, v1.10]
+Branch:
+  name: main
+Vars:
+  empty:
+  big: 12345678901234567890
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadMetadata(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GitTag{Raw: "1.10", Major: "1", Minor: "10", Month: "03", Latest: true, Fields: map[string]string{"build": "0042", "stage": "1e3"}}
+	if !reflect.DeepEqual(*m.Tag, want) {
+		t.Errorf("tag = %+v, want %+v", *m.Tag, want)
+	}
+	if m.Commit.ShortHash != "0123456" || !reflect.DeepEqual(m.Commit.Tags, []string{"1.10", "v1.10"}) {
+		t.Errorf("commit = %+v, want the hash and tags as written", *m.Commit)
+	}
+	if m.Branch.Name != "main" {
+		t.Errorf("branch = %q, want main", m.Branch.Name)
+	}
+	if want := map[string]string{"empty": "", "big": "12345678901234567890"}; !reflect.DeepEqual(m.Vars, want) {
+		t.Errorf("vars = %v, want %v", m.Vars, want)
+	}
+}
+
+func TestDecodeYAMLFallsBack(t *testing.T) {
+	// keys that can't be a field tag and lists of maps are decoded as yaml
+	// resolves them
+	doc, err := decodeYAML([]byte(`
+"a,b": 1.10
+list:
+  - {version: 2.0}
+  - true
+flags: [yes, "no"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a,b":   "1.1",
+		"list":  []interface{}{map[string]interface{}{"version": "2"}, true},
+		"flags": []interface{}{true, "no"},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("got %#v, want %#v", doc, want)
+	}
+}
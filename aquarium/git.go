@@ -0,0 +1,500 @@
+package aquarium
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitBranch is the branch checked out, or the branch a ref names
+type GitBranch struct {
+	Name string
+}
+
+// GitCommit is the commit the ref points at
+type GitCommit struct {
+	ShortHash   string
+	LongHash    string
+	AuthorName  string
+	AuthorEmail string
+	// Branches are the local and remote branches containing the commit
+	Branches []string
+	// Tags are the tags pointing at the commit
+	Tags []string
+	// Time is the commit date
+	Time time.Time
+}
+
+// GitTag is the closest tag reachable from the ref, split into its parts when
+// it follows semver, the calver scheme or the tag_pattern
+type GitTag struct {
+	Major  string
+	Minor  string
+	Patch  string
+	Raw    string
+	SemVer bool
+
+	// CalVer is set when the tag follows the configured calendar versioning
+	// scheme, only the parts in the scheme are filled (Major and Minor too)
+	CalVer   bool
+	Year     string
+	Month    string
+	Week     string
+	Day      string
+	Micro    string
+	Modifier string
+	// Fields are the named groups of the tag_pattern
+	Fields map[string]string
+	// Latest is set when no tag in the repository is a higher version of the
+	// same scheme
+	Latest bool
+
+	// Name is the tag as it is called in git, Raw without the v stripped
+	Name string
+	// Annotated is false for lightweight tags, which have no message or tagger
+	Annotated   bool
+	Message     string
+	TaggerName  string
+	TaggerEmail string
+	// Date is when the tag was created, the commit date of lightweight tags
+	Date time.Time
+}
+
+// GitRepo describes the origin remote
+type GitRepo struct {
+	Remote string
+	URL    string
+	Name   string
+}
+
+// Metadata is everything known about the repository, it is what tag and
+// label templates are executed against
+type Metadata struct {
+	Tag    *GitTag
+	Commit *GitCommit
+	Branch *GitBranch
+	Repo   *GitRepo
+	// BuildTime is when the build happened, SOURCE_DATE_EPOCH when set
+	BuildTime time.Time
+	// Created is BuildTime in RFC 3339
+	Created string
+	// Signature is the verified signature of the tag or commit, nil unless
+	// the Collector was asked to Verify it
+	Signature *GitSignature
+	// Vars are the user defined variables of the vars section
+	Vars map[string]string
+	// PullRequest is the pull request being built, nil for other builds
+	PullRequest *GitPullRequest
+}
+
+// Collector gathers the Metadata of a git repository
+type Collector struct {
+	// Dir is the working tree git runs in, the current directory when empty
+	Dir string
+	// Ref is the commit, tag or branch described, HEAD when empty
+	Ref string
+	// FetchMissing deepens a shallow clone and fetches the tags when the
+	// metadata cannot be read without them, instead of failing
+	FetchMissing bool
+	// Vars are resolved into Metadata.Vars, files and commands relative to Dir
+	Vars map[string]Var
+	// Location is the time zone of the times, UTC when nil
+	Location *time.Location
+	// CalVer parses tags with a calendar versioning scheme before semver
+	CalVer *CalVerScheme
+	// TagPattern parses tags before CalVer and semver
+	TagPattern *TagPattern
+	// Reproducible uses the commit date as build time when SOURCE_DATE_EPOCH
+	// is not set, so every run for a commit renders the same
+	Reproducible bool
+}
+
+// Collect reads the tag, commit, branch and remote of the repository and
+// resolves the Vars
+func (c *Collector) Collect(ctx context.Context) (*Metadata, error) {
+	data, err := c.collectGit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if data.Vars, err = c.resolveVars(ctx); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// collectGit reads the git metadata. When that fails because the clone is
+// shallow or is missing tags, the missing data is fetched if FetchMissing is
+// set, the error says what is missing otherwise.
+func (c *Collector) collectGit(ctx context.Context) (*Metadata, error) {
+	data, err := c.collect(ctx)
+	if err == nil {
+		return data, nil
+	}
+
+	shallow, missing := c.incomplete(ctx)
+	if missing == "" {
+		return nil, err
+	}
+	if !c.FetchMissing {
+		fix := "git fetch --tags"
+		if shallow {
+			fix = "git fetch --unshallow --tags"
+		}
+		return nil, fmt.Errorf("%v\n\n%s: run `%s` before aquarium, clone with the full history (e.g. fetch-depth: 0) or set fetch_missing", err, missing, fix)
+	}
+
+	args := []string{"fetch", "--tags"}
+	if shallow {
+		args = append(args, "--unshallow")
+	}
+	if _, err := c.git(ctx, args...); err != nil {
+		return nil, fmt.Errorf("%s, fetching it failed: %v", missing, err)
+	}
+	return c.collect(ctx)
+}
+
+// incomplete explains what a shallow clone or a clone without tags is
+// missing, empty when the repository is complete
+func (c *Collector) incomplete(ctx context.Context) (bool, string) {
+	var shallow bool
+	if out, err := c.git(ctx, "rev-parse", "--is-shallow-repository"); err == nil {
+		shallow = strings.TrimSpace(out) == "true"
+	}
+	tags, _ := c.git(ctx, "tag", "--list")
+	tagOpt, _ := c.git(ctx, "config", "--get", "remote.origin.tagOpt")
+
+	switch {
+	case shallow:
+		depth, _ := c.git(ctx, "rev-list", "--count", "HEAD")
+		return true, fmt.Sprintf("the repository is a shallow clone with %s commits of history, the tags and commits describing %s may not have been fetched", strings.TrimSpace(depth), c.ref())
+	case strings.TrimSpace(tags) == "":
+		return false, "the repository has no tags, they may not have been fetched"
+	case strings.TrimSpace(tagOpt) == "--no-tags":
+		return false, "the repository was cloned with --no-tags, only some of the tags may have been fetched"
+	}
+	return false, ""
+}
+
+func (c *Collector) collect(ctx context.Context) (*Metadata, error) {
+	tag, err := c.getTag(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := c.getCommit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	branch, err := c.getBranch(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := c.getRepo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := c.getPullRequest(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+	// CI checks pull requests out detached, the source branch is what was built
+	if pr != nil && pr.SourceBranch != "" && branch.Name == "HEAD" {
+		branch.Name = pr.SourceBranch
+	}
+
+	buildTime, err := c.buildTime(commit.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metadata{
+		Tag:         tag,
+		Branch:      branch,
+		Commit:      commit,
+		Repo:        repo,
+		BuildTime:   buildTime,
+		Created:     buildTime.Format(time.RFC3339),
+		PullRequest: pr,
+	}, nil
+}
+
+// buildTime is SOURCE_DATE_EPOCH (see https://reproducible-builds.org/specs/source-date-epoch/)
+// when set, the commit date for reproducible builds and now otherwise
+func (c *Collector) buildTime(commitTime time.Time) (time.Time, error) {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH %q is not a unix timestamp", epoch)
+		}
+		return c.inLocation(time.Unix(seconds, 0)), nil
+	}
+	if c.Reproducible {
+		return commitTime, nil
+	}
+	return c.inLocation(time.Now().Truncate(time.Second)), nil
+}
+
+func (c *Collector) inLocation(t time.Time) time.Time {
+	if c.Location == nil {
+		return t.UTC()
+	}
+	return t.In(c.Location)
+}
+
+// hasRef reports whether the full ref name exists
+func (c *Collector) hasRef(ctx context.Context, name string) bool {
+	_, err := c.git(ctx, "show-ref", "--verify", "--quiet", name)
+	return err == nil
+}
+
+func (c *Collector) ref() string {
+	return firstNonEmpty(c.Ref, "HEAD")
+}
+
+func (c *Collector) git(ctx context.Context, args ...string) (string, error) {
+	var cmd = exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = c.Dir
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return stdout.String(), nil
+}
+
+// getTag tries to imitate `git describe --tags` command to retreive the tag on
+// the ref, a ref naming a tag is that tag
+func (c *Collector) getTag(ctx context.Context) (*GitTag, error) {
+	tag := c.Ref
+	if tag == "" || !c.hasRef(ctx, "refs/tags/"+tag) {
+		raw, err := c.git(ctx, "describe", "--tags", "--abbrev=0", c.ref())
+		if err != nil {
+			return nil, err
+		}
+		tag = strings.TrimSpace(raw)
+	}
+
+	t, err := c.annotation(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	// does the tag start with v? strip it
+	t.Raw = strings.TrimPrefix(tag, "v")
+
+	v := c.parseVersion(tag)
+	if v == nil {
+		// well the tag follows no scheme.. so lets just return the raw value
+		return t, nil
+	}
+	v.fill(t)
+	t.Latest, err = c.isLatest(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// annotation reads the message, tagger and date of an annotated tag, for a
+// lightweight tag only the date of the commit it points at is known
+func (c *Collector) annotation(ctx context.Context, name string) (*GitTag, error) {
+	out, err := c.git(ctx, "for-each-ref", "--count=1",
+		"--format=%(objecttype)%00%(taggername)%00%(taggeremail)%00%(taggerdate:iso-strict)%00%(committerdate:iso-strict)%00%(contents:subject)%00%(contents:body)",
+		"refs/tags/"+name)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(strings.TrimSuffix(out, "\n"), "\x00", 7)
+	if len(fields) < 7 {
+		return &GitTag{Name: name}, nil
+	}
+
+	t := &GitTag{
+		Name:      name,
+		Annotated: fields[0] == "tag",
+	}
+	date := fields[3]
+	if !t.Annotated {
+		date = fields[4]
+	}
+	if date = strings.TrimSpace(date); date != "" {
+		if t.Date, err = time.Parse(time.RFC3339, date); err != nil {
+			return nil, fmt.Errorf("reading the date of tag %s: %v", name, err)
+		}
+		t.Date = c.inLocation(t.Date)
+	}
+	if !t.Annotated {
+		return t, nil
+	}
+	t.TaggerName = fields[1]
+	t.TaggerEmail = strings.Trim(fields[2], "<>")
+	t.Message = strings.TrimSpace(fields[5] + "\n\n" + fields[6])
+	return t, nil
+}
+
+func (c *Collector) getCommit(ctx context.Context) (*GitCommit, error) {
+	longHash, err := c.git(ctx, "rev-parse", "--verify", c.ref()+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	longHash = strings.TrimSpace(longHash)
+
+	shortHash, err := c.git(ctx, "rev-parse", "--short", longHash)
+	if err != nil {
+		return nil, err
+	}
+
+	author, err := c.git(ctx, "log", "-1", "--format=%an%n%ae", longHash)
+	if err != nil {
+		return nil, err
+	}
+	authorName, authorEmail := splitTwoLines(author)
+
+	committed, err := c.git(ctx, "log", "-1", "--format=%ct", longHash)
+	if err != nil {
+		return nil, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(committed), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("reading the commit date of %s: %v", longHash, err)
+	}
+
+	branches, err := c.git(ctx, "for-each-ref", "--contains", longHash, "--format=%(refname)", "refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	tags, err := c.git(ctx, "tag", "--points-at", longHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GitCommit{
+		LongHash:    longHash,
+		ShortHash:   strings.TrimSpace(shortHash),
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+		Branches:    branchNames(branches),
+		Tags:        strings.Fields(tags),
+		Time:        c.inLocation(time.Unix(seconds, 0)),
+	}, nil
+}
+
+// getBranch returns the branch the ref names, the checked out branch for HEAD
+// and otherwise the first branch containing the commit
+func (c *Collector) getBranch(ctx context.Context, commit *GitCommit) (*GitBranch, error) {
+	if c.Ref == "" {
+		name, err := c.git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			return nil, err
+		}
+		return &GitBranch{
+			Name: strings.TrimSpace(name),
+		}, nil
+	}
+
+	if c.hasRef(ctx, "refs/heads/"+c.Ref) || c.hasRef(ctx, "refs/remotes/"+c.Ref) {
+		return &GitBranch{Name: c.Ref}, nil
+	}
+	if len(commit.Branches) > 0 {
+		return &GitBranch{Name: commit.Branches[0]}, nil
+	}
+	return &GitBranch{}, nil
+}
+
+// branchNames parses the for-each-ref listing of full ref names, local
+// branches come before remote ones and symbolic refs like origin/HEAD are
+// left out
+func branchNames(output string) []string {
+	var local, remote []string
+	for _, ref := range strings.Fields(output) {
+		switch {
+		case strings.HasSuffix(ref, "/HEAD"):
+		case strings.HasPrefix(ref, "refs/heads/"):
+			local = append(local, strings.TrimPrefix(ref, "refs/heads/"))
+		case strings.HasPrefix(ref, "refs/remotes/"):
+			remote = append(remote, strings.TrimPrefix(ref, "refs/remotes/"))
+		}
+	}
+	return append(local, remote...)
+}
+
+// getRepo reads the origin remote, a repository without one simply has no source url
+func (c *Collector) getRepo(ctx context.Context) (*GitRepo, error) {
+	remote, err := c.git(ctx, "config", "--get", "remote.origin.url")
+	if err != nil {
+		return &GitRepo{}, nil
+	}
+	remote = strings.TrimSpace(remote)
+	webURL := remoteToURL(remote)
+
+	return &GitRepo{
+		Remote: remote,
+		URL:    webURL,
+		Name:   strings.TrimSuffix(path.Base(webURL), ".git"),
+	}, nil
+}
+
+// remoteToURL turns a git remote (scp-like or url) into a browsable https url
+// without credentials, e.g. git@github.com:org/repo.git -> https://github.com/org/repo
+func remoteToURL(remote string) string {
+	if remote == "" {
+		return ""
+	}
+
+	if !strings.Contains(remote, "://") {
+		// scp-like syntax user@host:path
+		if i := strings.Index(remote, ":"); i > 0 {
+			host := remote[:i]
+			if at := strings.LastIndex(host, "@"); at >= 0 {
+				host = host[at+1:]
+			}
+			return "https://" + host + "/" + strings.TrimSuffix(strings.TrimPrefix(remote[i+1:], "/"), ".git")
+		}
+		return remote
+	}
+
+	u, err := url.Parse(remote)
+	if err != nil {
+		return remote
+	}
+	u.User = nil
+	if u.Scheme != "http" && u.Scheme != "https" {
+		u.Scheme = "https"
+		u.Host = u.Hostname()
+	}
+	u.Path = strings.TrimSuffix(u.Path, ".git")
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func splitTwoLines(s string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(s), "\n", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
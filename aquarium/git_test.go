@@ -0,0 +1,84 @@
+package aquarium
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runGit runs git in dir with a fixed identity and no user config
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"HOME="+dir,
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newUpstream creates a repository with a commit for every tag, in order
+func newUpstream(t *testing.T, dir string, tags ...string) string {
+	upstream := filepath.Join(dir, "upstream")
+	if err := os.Mkdir(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, upstream, "init", "-q")
+	for _, tag := range tags {
+		runGit(t, upstream, "commit", "-q", "--allow-empty", "-m", tag)
+		runGit(t, upstream, "tag", tag)
+	}
+	return upstream
+}
+
+func TestLatestNeedsEveryTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	upstream := newUpstream(t, dir, "v1.2.0", "v1.10.0")
+	ctx := context.Background()
+
+	shallow := filepath.Join(dir, "shallow")
+	runGit(t, dir, "clone", "-q", "--depth", "1", "--branch", "v1.2.0", "file://"+upstream, shallow)
+	if _, err := (&Collector{Dir: shallow}).Collect(ctx); err == nil || !strings.Contains(err.Error(), "shallow clone") {
+		t.Fatalf("got %v, want the shallow clone reported", err)
+	}
+	data, err := (&Collector{Dir: shallow, FetchMissing: true}).Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Tag.Raw != "1.2.0" || data.Tag.Latest {
+		t.Errorf("got %s latest %v, want 1.2.0 not the latest", data.Tag.Raw, data.Tag.Latest)
+	}
+
+	noTags := filepath.Join(dir, "no-tags")
+	runGit(t, dir, "clone", "-q", "--no-tags", "file://"+upstream, noTags)
+	runGit(t, noTags, "fetch", "-q", "origin", "tag", "v1.2.0")
+	if _, err := (&Collector{Dir: noTags, Ref: "v1.2.0"}).Collect(ctx); err == nil || !strings.Contains(err.Error(), "--no-tags") {
+		t.Fatalf("got %v, want the missing tags reported", err)
+	}
+
+	full := filepath.Join(dir, "full")
+	runGit(t, dir, "clone", "-q", "file://"+upstream, full)
+	for ref, latest := range map[string]bool{"v1.2.0": false, "v1.10.0": true} {
+		data, err := (&Collector{Dir: full, Ref: ref}).Collect(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Tag.Latest != latest {
+			t.Errorf("%s latest = %v, want %v", ref, data.Tag.Latest, latest)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package aquarium
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// tagPatterns classifies rendered tags, like the immutable ones (release
+// versions that must never be moved) as opposed to those moved on every run
+type tagPatterns []*regexp.Regexp
+
+// newTagPatterns compiles the patterns of the config setting named setting
+func newTagPatterns(setting string, patterns []string) (tagPatterns, error) {
+	var p tagPatterns
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s pattern %q: %v", setting, pattern, err)
+		}
+		p = append(p, re)
+	}
+	return p, nil
+}
+
+func (p tagPatterns) match(tag string) bool {
+	for _, re := range p {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImmutable refuses to move an immutable tag that already points at a
+// different image, in the backend or, when pushing, in the registry
+func checkImmutable(ctx context.Context, jobs []*tagJob, immutable tagPatterns, remote *registryClient, push bool, concurrency int) error {
+	errs := runParallel(len(jobs), concurrency, func(i int) error {
+		job := jobs[i]
+		target, err := ParseReference(job.ref)
+		if err != nil {
+			return err
+		}
+		if !immutable.match(target.Tag) {
+			return nil
+		}
+
+		backend := job.image.backend
+		want, err := backend.Inspect(ctx, job.image.source)
+		if err != nil {
+			return fmt.Errorf("inspecting %s: %v", job.image.source, err)
+		}
+
+		current, err := backend.Current(ctx, job.ref)
+		if err != nil {
+			return fmt.Errorf("looking up %s: %v", job.ref, err)
+		}
+		if current != "" {
+			have, err := backend.Inspect(ctx, current)
+			if err != nil {
+				return fmt.Errorf("inspecting %s: %v", current, err)
+			}
+			if have.ID != want.ID {
+				return fmt.Errorf("%s is immutable and already points at %s, refusing to move it to %s (use -force)", job.ref, have.ID, want.ID)
+			}
+		}
+
+		if !push {
+			return nil
+		}
+		_, _, digest, err := remote.getManifest(ctx, target)
+		if isNotFound(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("looking up %s in the registry: %v", job.ref, err)
+		}
+		if digest != want.ID && !contains(want.Digests, target.Name()+"@"+digest) && !contains(want.Digests, job.image.name+"@"+digest) {
+			return fmt.Errorf("%s is immutable and already exists in the registry as %s, refusing to overwrite it (use -force)", job.ref, digest)
+		}
+		return nil
+	})
+	return collectErrors(errs)
+}
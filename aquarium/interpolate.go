@@ -0,0 +1,131 @@
+package aquarium
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Interpolate replaces ${VAR}, ${VAR:-default} and ${VAR:?error} in every
+// string of the config with the variables lookup returns, like a shell
+// would. $${ is a literal ${. Every missing required variable is reported
+// in a single error.
+func (c *Config) Interpolate(lookup func(string) (string, bool)) error {
+	i := &interpolator{lookup: lookup, missing: map[string]string{}}
+	i.walk(reflect.ValueOf(c).Elem())
+	if i.err != nil {
+		return i.err
+	}
+	if len(i.missing) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(i.missing))
+	for name := range i.missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for n, name := range names {
+		lines[n] = "  " + name
+		if msg := i.missing[name]; msg != "" {
+			lines[n] += ": " + msg
+		}
+	}
+	return fmt.Errorf("missing required environment variables:\n%s", strings.Join(lines, "\n"))
+}
+
+type interpolator struct {
+	lookup func(string) (string, bool)
+	// missing maps required variables that are not set to their error message
+	missing map[string]string
+	err     error
+}
+
+// walk interpolates every string reachable from v
+func (i *interpolator) walk(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(i.expand(v.String()))
+		}
+	case reflect.Struct:
+		for n := 0; n < v.NumField(); n++ {
+			i.walk(v.Field(n))
+		}
+	case reflect.Slice:
+		for n := 0; n < v.Len(); n++ {
+			i.walk(v.Index(n))
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.IsNil() {
+			return
+		}
+		expanded := reflect.MakeMap(v.Type())
+		for _, key := range v.MapKeys() {
+			k := reflect.ValueOf(i.expand(key.String())).Convert(v.Type().Key())
+			// map values are not addressable, walk a copy
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(key))
+			i.walk(e)
+			expanded.SetMapIndex(k, e)
+		}
+		v.Set(expanded)
+	}
+}
+
+// expand interpolates a single string
+func (i *interpolator) expand(s string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+
+	var out bytes.Buffer
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			out.WriteString(s)
+			return out.String()
+		}
+		if start > 0 && s[start-1] == '$' {
+			out.WriteString(s[:start-1] + "${")
+			s = s[start+2:]
+			continue
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			if i.err == nil {
+				i.err = fmt.Errorf("unterminated variable in %q", s)
+			}
+			return s
+		}
+		out.WriteString(s[:start])
+		out.WriteString(i.resolve(s[start+2 : start+end]))
+		s = s[start+end+1:]
+	}
+}
+
+// resolve returns the value of a single NAME, NAME:-default or NAME:?error
+// expression, a default or error applies when the variable is unset or empty
+func (i *interpolator) resolve(expr string) string {
+	name, op, arg := expr, "", ""
+	if n := strings.Index(expr, ":"); n >= 0 && len(expr) > n+1 && (expr[n+1] == '-' || expr[n+1] == '?') {
+		name, op, arg = expr[:n], expr[n:n+2], expr[n+2:]
+	}
+
+	value, _ := i.lookup(name)
+	if value != "" {
+		return value
+	}
+	switch op {
+	case ":-":
+		return arg
+	case ":?":
+		if _, ok := i.missing[name]; !ok || i.missing[name] == "" {
+			i.missing[name] = arg
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package aquarium
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testEnv = map[string]string{
+	"A":        "x",
+	"EMPTY":    "",
+	"REGISTRY": "registry.example.com",
+	"BACKEND":  "registry",
+}
+
+func lookupTestEnv(name string) (string, bool) {
+	value, ok := testEnv[name]
+	return value, ok
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"plain", "plain"},
+		{"$A {A}", "$A {A}"},
+		{"a${A}b", "axb"},
+		{"${A}${A}", "xx"},
+		{"${UNSET}", ""},
+		{"${UNSET:-default}", "default"},
+		{"${EMPTY:-default}", "default"},
+		{"${A:-default}", "x"},
+		{"${UNSET:-}", ""},
+		{"$${A}", "${A}"},
+		{"$${A} ${A}", "${A} x"},
+		{"cost: $$5 ${A}", "cost: $$5 x"},
+	}
+	for _, tt := range tests {
+		i := &interpolator{lookup: lookupTestEnv, missing: map[string]string{}}
+		if got := i.expand(tt.in); got != tt.out || i.err != nil || len(i.missing) > 0 {
+			t.Errorf("expand(%q) = %q (%v, missing %v), want %q", tt.in, got, i.err, i.missing, tt.out)
+		}
+	}
+}
+
+func TestExpandUnterminated(t *testing.T) {
+	i := &interpolator{lookup: lookupTestEnv, missing: map[string]string{}}
+	if got := i.expand("a ${A"); got != "a ${A" || i.err == nil {
+		t.Errorf("expand of an unterminated variable = %q, %v, want it unchanged and an error", got, i.err)
+	}
+}
+
+func TestInterpolateRequired(t *testing.T) {
+	config := &Config{
+		ImageNames: []string{"${REGISTRY:?}/app", "${NAME:?set NAME to the image name}"},
+		TagFormat:  []string{"${TOKEN:?}", "${TOKEN:?needed for tags}", "${EMPTY:?}"},
+	}
+	err := config.Interpolate(lookupTestEnv)
+	want := "missing required environment variables:\n  EMPTY\n  NAME: set NAME to the image name\n  TOKEN: needed for tags"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
+
+func TestInterpolateMapsAndVars(t *testing.T) {
+	config := &Config{
+		ImageBackends: map[string]string{"${REGISTRY}/app": "${BACKEND}"},
+		Vars: map[string]Var{
+			"version": {Value: "${A}"},
+			"name":    {Command: "echo ${UNSET:-fallback}"},
+		},
+		Signatures: SignatureConfig{GPGKeys: []string{"${A}"}},
+	}
+	if err := config.Interpolate(lookupTestEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]string{"registry.example.com/app": "registry"}; !reflect.DeepEqual(config.ImageBackends, want) {
+		t.Errorf("image_backends = %v, want %v", config.ImageBackends, want)
+	}
+	want := map[string]Var{
+		"version": {Value: "x"},
+		"name":    {Command: "echo fallback"},
+	}
+	if !reflect.DeepEqual(config.Vars, want) {
+		t.Errorf("vars = %+v, want %+v", config.Vars, want)
+	}
+	if config.Signatures.GPGKeys[0] != "x" {
+		t.Errorf("signatures.gpg_keys = %v, want [x]", config.Signatures.GPGKeys)
+	}
+}
@@ -0,0 +1,124 @@
+package aquarium
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// manifestSchemaVersion is bumped whenever the manifest layout changes in a
+// way readers need to know about
+const manifestSchemaVersion = 1
+
+// Manifest describes everything a single aquarium run produced, it is
+// meant to be archived as a CI artifact and consumed by deploy jobs
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	StartedAt     time.Time         `json:"startedAt"`
+	FinishedAt    time.Time         `json:"finishedAt"`
+	ConfigHash    string            `json:"configHash"`
+	Git           *Metadata         `json:"git"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Images        []ManifestImage   `json:"images"`
+}
+
+type ManifestImage struct {
+	Name     string    `json:"name"`
+	ID       string    `json:"id"`
+	Tags     []string  `json:"tags"`
+	Digests  []string  `json:"digests,omitempty"`
+	TaggedAt time.Time `json:"taggedAt"`
+}
+
+// NewManifest starts the manifest of a run using the config in configData
+func NewManifest(configData []byte, tmplData *Metadata, labels map[string]string) *Manifest {
+	return &Manifest{
+		SchemaVersion: manifestSchemaVersion,
+		StartedAt:     time.Now().UTC(),
+		ConfigHash:    fmt.Sprintf("sha256:%x", sha256.Sum256(configData)),
+		Git:           tmplData,
+		Labels:        labels,
+		Images:        []ManifestImage{},
+	}
+}
+
+// AddImage records the tags applied to name, together with the image id, the
+// digests pushed and any registry digests the backend knows for that repository
+func (m *Manifest) AddImage(ctx context.Context, name, source string, tags, pushed []string, backend Tagger) error {
+	info, err := backend.Inspect(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	digests := pushed
+	for _, d := range info.Digests {
+		if strings.HasPrefix(d, name+"@") && !contains(digests, d) {
+			digests = append(digests, d)
+		}
+	}
+
+	m.Record(name, info.ID, tags, digests)
+	return nil
+}
+
+// Record adds an image entry to the manifest
+func (m *Manifest) Record(name, id string, tags, digests []string) {
+	m.Images = append(m.Images, ManifestImage{
+		Name:     name,
+		ID:       id,
+		Tags:     tags,
+		Digests:  digests,
+		TaggedAt: time.Now().UTC(),
+	})
+}
+
+// Image returns the entry recorded for name
+func (m *Manifest) Image(name string) (*ManifestImage, error) {
+	for i, img := range m.Images {
+		if img.Name == name {
+			return &m.Images[i], nil
+		}
+	}
+	return nil, fmt.Errorf("manifest has no image named %s", name)
+}
+
+// Write stamps the finish time and stores the manifest as indented JSON
+func (m *Manifest) Write(path string) error {
+	m.FinishedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadManifest loads a manifest written by a previous run
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.SchemaVersion > manifestSchemaVersion {
+		return nil, fmt.Errorf("manifest %s has schema version %d, this aquarium only understands up to %d", path, m.SchemaVersion, manifestSchemaVersion)
+	}
+	return m, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,362 @@
+package aquarium
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	ociLayoutFile        = "oci-layout"
+	ociIndexFile         = "index.json"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    json.RawMessage   `json:"platform,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor   `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ociBackend tags images exported as an OCI image layout directory, tags are
+// recorded as ref.name annotations on the index.json entries. The ref.name is
+// the full reference, so several image names can share one layout.
+type ociBackend struct {
+	dir string
+
+	// index.json is read, changed and written back by every operation
+	mu sync.Mutex
+	// entries whose only name was moved away this run, kept so rolling back
+	// can still point the name at them
+	dropped map[string]ociDescriptor
+	// what Label added for a labeled manifest digest, so Unlabel removes
+	// exactly that
+	labeled map[string]ociLabeled
+}
+
+type ociLabeled struct {
+	blobs []string
+	entry bool
+}
+
+// NewOCITagger works on the OCI image layout in dir
+func NewOCITagger(dir string) (Tagger, error) {
+	if dir == "" {
+		return nil, errors.New("the oci backend needs an image layout directory, set oci_layout or -oci-layout")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ociLayoutFile)); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout: %v", dir, err)
+	}
+	return &ociBackend{dir: dir, dropped: map[string]ociDescriptor{}, labeled: map[string]ociLabeled{}}, nil
+}
+
+func (o *ociBackend) readIndex() (*ociIndex, error) {
+	data, err := ioutil.ReadFile(filepath.Join(o.dir, ociIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	index := &ociIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", ociIndexFile, err)
+	}
+	return index, nil
+}
+
+// writeIndex replaces index.json through a rename so readers never see a
+// partially written file
+func (o *ociBackend) writeIndex(index *ociIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(o.dir, ociIndexFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(o.dir, ociIndexFile))
+}
+
+func (o *ociBackend) blobPath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[1], "/\\.") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(o.dir, "blobs", parts[0], parts[1]), nil
+}
+
+func (o *ociBackend) getBlob(digest string) ([]byte, error) {
+	path, err := o.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+func (o *ociBackend) putBlob(blob []byte) (string, error) {
+	digest := digestOf(blob)
+	path, err := o.blobPath(digest)
+	if err != nil {
+		return "", err
+	}
+	return digest, ioutil.WriteFile(path, blob, 0644)
+}
+
+// putNewBlob is putBlob adding the digests of blobs that did not exist yet to
+// created
+func (o *ociBackend) putNewBlob(created *[]string) func([]byte) (string, error) {
+	return func(blob []byte) (string, error) {
+		path, err := o.blobPath(digestOf(blob))
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			*created = append(*created, digestOf(blob))
+		}
+		return o.putBlob(blob)
+	}
+}
+
+// ociRefName is the ref.name ref is recorded as, the normalized reference so
+// app:1.0 and docker.io/library/app:1.0 name the same entry
+func ociRefName(ref string) (string, error) {
+	target, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	return target.String(), nil
+}
+
+// find returns the index entry source names, either by manifest digest or by
+// an existing ref.name
+func (o *ociBackend) find(index *ociIndex, source string) (*ociDescriptor, error) {
+	// ref.names other tools wrote, like bare tags, only match as written
+	refName, _ := ociRefName(source)
+	for i, m := range index.Manifests {
+		name := m.Annotations[ociRefNameAnnotation]
+		if m.Digest == source || name == source || (name != "" && name == refName) {
+			return &index.Manifests[i], nil
+		}
+	}
+	if desc, ok := o.dropped[source]; ok {
+		return &desc, nil
+	}
+	return nil, fmt.Errorf("image %s not found in OCI layout %s", source, o.dir)
+}
+
+// Resolve looks the image up in index.json and returns its manifest digest, a
+// bare tag is one of the tags of name
+func (o *ociBackend) Resolve(ctx context.Context, source, name string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	index, err := o.readIndex()
+	if err != nil {
+		return "", err
+	}
+	desc, err := o.find(index, source)
+	if err != nil {
+		if refName, perr := ociRefName(name + ":" + source); perr == nil {
+			if tagged, ferr := o.find(index, refName); ferr == nil {
+				return tagged.Digest, nil
+			}
+		}
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+func (o *ociBackend) Inspect(ctx context.Context, source string) (*ImageInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	index, err := o.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	desc, err := o.find(index, source)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageInfo{ID: desc.Digest}, nil
+}
+
+// Label writes a relabeled config and manifest into the layout and adds the
+// new manifest to the index without a ref.name, tagging it names it
+func (o *ociBackend) Label(ctx context.Context, source string, labels map[string]string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	index, err := o.readIndex()
+	if err != nil {
+		return "", err
+	}
+	desc, err := o.find(index, source)
+	if err != nil {
+		return "", err
+	}
+	manifestData, err := o.getBlob(desc.Digest)
+	if err != nil {
+		return "", err
+	}
+
+	var created ociLabeled
+	manifestData, err = relabelManifest(manifestData, labels, o.getBlob, o.putNewBlob(&created.blobs))
+	if err != nil {
+		return "", fmt.Errorf("labeling %s: %v", source, err)
+	}
+	digest, err := o.putNewBlob(&created.blobs)(manifestData)
+	if err != nil {
+		return "", err
+	}
+
+	labeled := *desc
+	labeled.Digest = digest
+	labeled.Size = int64(len(manifestData))
+	labeled.Annotations = nil
+	if _, err := o.find(index, digest); err != nil {
+		index.Manifests = append(index.Manifests, labeled)
+		created.entry = true
+	}
+	o.labeled[digest] = created
+	return digest, o.writeIndex(index)
+}
+
+// Unlabel drops the unnamed index entry and the blobs Label added
+func (o *ociBackend) Unlabel(ctx context.Context, labeled string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	created, ok := o.labeled[labeled]
+	if !ok {
+		return nil
+	}
+	if created.entry {
+		index, err := o.readIndex()
+		if err != nil {
+			return err
+		}
+		var kept []ociDescriptor
+		for _, m := range index.Manifests {
+			if m.Digest != labeled || m.Annotations[ociRefNameAnnotation] != "" {
+				kept = append(kept, m)
+			}
+		}
+		index.Manifests = kept
+		if err := o.writeIndex(index); err != nil {
+			return err
+		}
+	}
+	for _, digest := range created.blobs {
+		path, err := o.blobPath(digest)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(o.labeled, labeled)
+	return nil
+}
+
+// Tag records ref as the ref.name of source, moving the name away from any
+// other image that carried it
+func (o *ociBackend) Tag(ctx context.Context, source, ref string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	refName, err := ociRefName(ref)
+	if err != nil {
+		return err
+	}
+
+	index, err := o.readIndex()
+	if err != nil {
+		return err
+	}
+	desc, err := o.find(index, source)
+	if err != nil {
+		return err
+	}
+
+	tagged := *desc
+	tagged.Annotations = map[string]string{}
+	for k, v := range desc.Annotations {
+		tagged.Annotations[k] = v
+	}
+	tagged.Annotations[ociRefNameAnnotation] = refName
+
+	index.Manifests = append(o.without(index.Manifests, refName), tagged)
+	return o.writeIndex(index)
+}
+
+// Current returns the manifest digest carrying ref as ref.name
+func (o *ociBackend) Current(ctx context.Context, ref string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	refName, err := ociRefName(ref)
+	if err != nil {
+		return "", err
+	}
+	index, err := o.readIndex()
+	if err != nil {
+		return "", err
+	}
+	for _, m := range index.Manifests {
+		if m.Annotations[ociRefNameAnnotation] == refName {
+			return m.Digest, nil
+		}
+	}
+	return "", nil
+}
+
+// Untag drops the index entry named ref
+func (o *ociBackend) Untag(ctx context.Context, ref string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	refName, err := ociRefName(ref)
+	if err != nil {
+		return err
+	}
+	index, err := o.readIndex()
+	if err != nil {
+		return err
+	}
+	index.Manifests = o.without(index.Manifests, refName)
+	return o.writeIndex(index)
+}
+
+// without returns manifests minus the entries named refName
+func (o *ociBackend) without(manifests []ociDescriptor, refName string) []ociDescriptor {
+	var kept []ociDescriptor
+	for _, m := range manifests {
+		if m.Annotations[ociRefNameAnnotation] != refName {
+			kept = append(kept, m)
+		} else if _, ok := o.dropped[m.Digest]; !ok {
+			o.dropped[m.Digest] = m
+		}
+	}
+	return kept
+}
+
+func (o *ociBackend) Push(ctx context.Context, ref string) (string, error) {
+	return "", errors.New("the oci backend cannot push, the image layout only exists on disk")
+}
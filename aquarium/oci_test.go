@@ -0,0 +1,168 @@
+package aquarium
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newOCILayout writes a layout holding a single image tagged 1.0 into dir
+func newOCILayout(t *testing.T, dir string) (*ociBackend, string) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ociLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tagger, err := NewOCITagger(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := tagger.(*ociBackend)
+	config, err := o.putBlob([]byte(`{"config":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"digest":%q}}`, mediaTypeOCIManifest, config))
+	digest, err := o.putBlob(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.writeIndex(&ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{{
+		MediaType:   mediaTypeOCIManifest,
+		Digest:      digest,
+		Size:        int64(len(manifest)),
+		Annotations: map[string]string{ociRefNameAnnotation: "1.0"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, digest
+}
+
+func blobCount(t *testing.T, o *ociBackend) int {
+	files, err := ioutil.ReadDir(filepath.Join(o.dir, "blobs", "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestOCIUnlabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	o, digest := newOCILayout(t, dir)
+	ctx := context.Background()
+
+	labeled, err := o.Label(ctx, digest, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := o.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 2 || blobCount(t, o) != 4 {
+		t.Fatalf("labeling left %d index entries and %d blobs, want 2 and 4", len(index.Manifests), blobCount(t, o))
+	}
+
+	if err := o.Unlabel(ctx, labeled); err != nil {
+		t.Fatal(err)
+	}
+	if index, err = o.readIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != digest || blobCount(t, o) != 2 {
+		t.Errorf("unlabeling left %v and %d blobs, want only the original image", index.Manifests, blobCount(t, o))
+	}
+}
+
+func TestOCITagSharedLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	o, digest := newOCILayout(t, dir)
+	ctx := context.Background()
+
+	labeled, err := o.Label(ctx, digest, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []struct{ source, ref string }{
+		{digest, "example.com/a:1.0"},
+		{labeled, "example.com/b:1.0"},
+		{labeled, "app:2.0"},
+	} {
+		if err := o.Tag(ctx, tag.source, tag.ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the same tag of two image names are two entries
+	for ref, want := range map[string]string{
+		"example.com/a:1.0":         digest,
+		"example.com/b:1.0":         labeled,
+		"docker.io/library/app:2.0": labeled,
+		"example.com/a:2.0":         "",
+	} {
+		if got, err := o.Current(ctx, ref); err != nil || got != want {
+			t.Errorf("Current(%s) = %q, %v, want %q", ref, got, err, want)
+		}
+	}
+
+	tests := []struct {
+		source, name, want string
+	}{
+		{digest, "example.com/a", digest},
+		{"example.com/b:1.0", "example.com/a", labeled},
+		{"app:2.0", "example.com/a", labeled},
+		// a ref.name written by another tool
+		{"1.0", "example.com/b", digest},
+		{"2.0", "app", labeled},
+		{"2.0", "example.com/a", ""},
+		{"example.com/c:1.0", "example.com/c", ""},
+	}
+	for _, test := range tests {
+		got, err := o.Resolve(ctx, test.source, test.name)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("Resolve(%s, %s) = %q, want an error", test.source, test.name, got)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("Resolve(%s, %s) = %q, %v, want %q", test.source, test.name, got, err, test.want)
+		}
+	}
+
+	// moving a tag leaves the other image name alone
+	if err := o.Tag(ctx, labeled, "example.com/a:1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Untag(ctx, "example.com/b:1.0"); err != nil {
+		t.Fatal(err)
+	}
+	for ref, want := range map[string]string{
+		"example.com/a:1.0": labeled,
+		"example.com/b:1.0": "",
+		"app:2.0":           labeled,
+	} {
+		if got, err := o.Current(ctx, ref); err != nil || got != want {
+			t.Errorf("after retagging, Current(%s) = %q, %v, want %q", ref, got, err, want)
+		}
+	}
+	index, err := o.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 4 {
+		t.Errorf("index has %d entries, want 4 (1.0, the unnamed labeled image, a:1.0 and app:2.0)", len(index.Manifests))
+	}
+}
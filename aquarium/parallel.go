@@ -0,0 +1,65 @@
+package aquarium
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const defaultConcurrency = 4
+
+// runParallel calls fn for every index below n with at most limit calls in
+// flight. Errors are returned by index so callers can report them in order.
+func runParallel(n, limit int, fn func(i int) error) []error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	errs := make([]error, n)
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// multiError reports every failed operation of a run at once
+type multiError struct {
+	total  int
+	errors []error
+}
+
+func (m *multiError) Error() string {
+	if len(m.errors) == 1 {
+		return m.errors[0].Error()
+	}
+	lines := make([]string, len(m.errors))
+	for i, err := range m.errors {
+		lines[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("%d of %d operations failed:\n%s", len(m.errors), m.total, strings.Join(lines, "\n"))
+}
+
+// collectErrors returns nil when errs holds no error and a multiError otherwise
+func collectErrors(errs []error) error {
+	m := &multiError{total: len(errs)}
+	for _, err := range errs {
+		if err != nil {
+			m.errors = append(m.errors, err)
+		}
+	}
+	if len(m.errors) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,127 @@
+package aquarium
+
+import (
+	"context"
+	"fmt"
+)
+
+// PromoteOptions controls how Promote applies the release tags
+type PromoteOptions struct {
+	// Tags are the tags applied in the target repository
+	Tags []string
+	// Force overwrites immutable tags that already exist with another image
+	Force bool
+	// ImmutableTags are the patterns of tags that must never be moved
+	ImmutableTags []string
+	// RequireSigned are the patterns of tags only applied when Signature
+	// is verified
+	RequireSigned []string
+	Signature     *GitSignature
+}
+
+// PromoteResult is what Promote did
+type PromoteResult struct {
+	// Digest is the digest of the promoted manifest, empty when every tag
+	// was blocked and nothing was copied
+	Digest string
+	// Tags are the tags applied in the target repository
+	Tags []string
+	// Blocked are the tags left out because they require a trusted signature
+	// the release doesn't have
+	Blocked []string
+}
+
+// Promote copies the image src to the repository dst and applies the tags
+// there
+func Promote(ctx context.Context, src, dst *ImageRef, opts PromoteOptions) (*PromoteResult, error) {
+	if dst.Tag != "" || dst.Digest != "" {
+		return nil, fmt.Errorf("promote target %s must be a repository without tag", dst)
+	}
+
+	result := &PromoteResult{}
+	var err error
+	if result.Blocked, err = unsignedTags(opts.Tags, opts.RequireSigned, opts.Signature); err != nil {
+		return nil, err
+	}
+	for _, tag := range opts.Tags {
+		if !contains(result.Blocked, tag) {
+			result.Tags = append(result.Tags, tag)
+		}
+	}
+	if len(result.Tags) == 0 && len(result.Blocked) > 0 {
+		return result, nil
+	}
+
+	immutable, err := newTagPatterns("immutable_tags", opts.ImmutableTags)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing is written to the target before the checks passed
+	registry := newRegistryClient()
+	_, _, digest, err := registry.getManifest(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Force {
+		for _, tag := range result.Tags {
+			if !immutable.match(tag) {
+				continue
+			}
+			existing := *dst
+			existing.Tag = tag
+			_, _, current, err := registry.getManifest(ctx, &existing)
+			if err != nil && !isNotFound(err) {
+				return nil, err
+			}
+			if err == nil && current != digest {
+				return nil, fmt.Errorf("%s is immutable and already exists as %s, refusing to overwrite it with %s (use -force)", existing.String(), current, digest)
+			}
+		}
+	}
+
+	// the source by digest, a tag moved meanwhile doesn't slip past the checks
+	pinned := *src
+	pinned.Tag, pinned.Digest = "", digest
+	manifestData, mediaType, err := registry.copyImage(ctx, &pinned, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range result.Tags {
+		if _, err := registry.putManifest(ctx, dst, tag, manifestData, mediaType); err != nil {
+			return nil, err
+		}
+	}
+	result.Digest = digest
+	return result, nil
+}
+
+// StagingImage finds the image to promote, by digest from the manifest of the
+// build when given or by its rendered commit tag
+func StagingImage(from, commitTag, manifestFile string, tmplData *Metadata) (*ImageRef, error) {
+	if manifestFile != "" {
+		m, err := ReadManifest(manifestFile)
+		if err != nil {
+			return nil, err
+		}
+		if m.Git != nil && m.Git.Commit != nil && m.Git.Commit.LongHash != tmplData.Commit.LongHash {
+			return nil, fmt.Errorf("manifest %s was written for commit %s, not %s", manifestFile, m.Git.Commit.LongHash, tmplData.Commit.LongHash)
+		}
+		img, err := m.Image(from)
+		if err != nil {
+			return nil, err
+		}
+		if len(img.Digests) == 0 {
+			return nil, fmt.Errorf("manifest %s has no pushed digest for %s", manifestFile, from)
+		}
+		return ParseReference(img.Digests[0])
+	}
+
+	tag, err := Render("commit_tag", commitTag, tmplData)
+	if err != nil {
+		return nil, err
+	}
+	return ParseReference(fmt.Sprintf("%s:%s", from, tag))
+}
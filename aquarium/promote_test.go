@@ -0,0 +1,86 @@
+package aquarium
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// stagedImage stores a single platform image in the app repository of r
+func stagedImage(r *fakeRegistry, tag, content string) string {
+	config := r.addBlob([]byte(`{"config":{"Labels":{"content":"` + content + `"}}}`))
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"digest":%q},"layers":[]}`, mediaTypeDockerManifest, config))
+	return r.addManifest("app", tag, manifest, mediaTypeDockerManifest)
+}
+
+func TestPromote(t *testing.T) {
+	staging := newFakeRegistry("")
+	defer staging.server.Close()
+	production := newFakeRegistry("")
+	defer production.server.Close()
+	digest := stagedImage(staging, "abc1234", "new")
+
+	got, err := Promote(context.Background(), staging.ref(t, "app:abc1234"), production.ref(t, "app"), PromoteOptions{
+		Tags: []string{"1.0.0", "latest"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Digest != digest || len(got.Blocked) > 0 {
+		t.Errorf("promoted %s, blocked %v, want %s", got.Digest, got.Blocked, digest)
+	}
+	for _, tag := range []string{"1.0.0", "latest"} {
+		if m, ok := production.manifests["app:"+tag]; !ok || digestOf(m.data) != digest {
+			t.Errorf("app:%s was not promoted", tag)
+		}
+	}
+}
+
+func TestPromoteImmutableWritesNothing(t *testing.T) {
+	staging := newFakeRegistry("")
+	defer staging.server.Close()
+	production := newFakeRegistry("")
+	defer production.server.Close()
+	stagedImage(staging, "abc1234", "new")
+	stagedImage(production, "1.0.0", "released")
+	manifests, blobs := len(production.manifests), len(production.blobs)
+
+	_, err := Promote(context.Background(), staging.ref(t, "app:abc1234"), production.ref(t, "app"), PromoteOptions{
+		Tags:          []string{"1.0.0"},
+		ImmutableTags: []string{`^\d+\.\d+\.\d+$`},
+	})
+	if err == nil || !strings.Contains(err.Error(), "immutable") {
+		t.Fatalf("got %v, want the immutable tag refused", err)
+	}
+	if len(production.manifests) != manifests || len(production.blobs) != blobs {
+		t.Errorf("a refused promote wrote to production: %d manifests and %d blobs, had %d and %d",
+			len(production.manifests), len(production.blobs), manifests, blobs)
+	}
+}
+
+func TestPromoteBlocksUnsignedTags(t *testing.T) {
+	staging := newFakeRegistry("")
+	defer staging.server.Close()
+	production := newFakeRegistry("")
+	defer production.server.Close()
+	stagedImage(staging, "abc1234", "new")
+
+	got, err := Promote(context.Background(), staging.ref(t, "app:abc1234"), production.ref(t, "app"), PromoteOptions{
+		Tags:          []string{"1.0.0", "latest"},
+		RequireSigned: []string{`^\d+\.\d+\.\d+$`},
+		Signature:     &GitSignature{Unsigned: true, Error: "commit abc is not signed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tags) != 1 || got.Tags[0] != "latest" || len(got.Blocked) != 1 || got.Blocked[0] != "1.0.0" {
+		t.Errorf("promoted %v and blocked %v, want latest promoted and 1.0.0 blocked", got.Tags, got.Blocked)
+	}
+	if _, ok := production.manifests["app:1.0.0"]; ok {
+		t.Error("the unsigned release tag was promoted")
+	}
+	if _, ok := production.manifests["app:latest"]; !ok {
+		t.Error("latest was not promoted")
+	}
+}
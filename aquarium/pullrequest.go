@@ -0,0 +1,154 @@
+package aquarium
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// GitPullRequest is the pull (or merge) request a build belongs to
+type GitPullRequest struct {
+	Number       string
+	SourceBranch string
+	TargetBranch string
+	// HeadSHA is the last commit of the source branch, which differs from
+	// the commit being built when CI checks out a merge commit
+	HeadSHA      string
+	HeadShortSHA string
+}
+
+// pullRequestEnv are the variables CI systems describe a pull request with,
+// number is empty (or "false") for builds that are not pull requests
+var pullRequestEnv = []struct {
+	number, source, target, head string
+}{
+	// gitlab
+	{"CI_MERGE_REQUEST_IID", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "CI_MERGE_REQUEST_TARGET_BRANCH_NAME", "CI_MERGE_REQUEST_SOURCE_BRANCH_SHA"},
+	// bitbucket pipelines
+	{"BITBUCKET_PR_ID", "BITBUCKET_BRANCH", "BITBUCKET_PR_DESTINATION_BRANCH", "BITBUCKET_COMMIT"},
+	// azure pipelines
+	{"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER", "SYSTEM_PULLREQUEST_SOURCEBRANCH", "SYSTEM_PULLREQUEST_TARGETBRANCH", "SYSTEM_PULLREQUEST_SOURCECOMMITID"},
+	// jenkins multibranch
+	{"CHANGE_ID", "CHANGE_BRANCH", "CHANGE_TARGET", "GIT_COMMIT"},
+	// travis
+	{"TRAVIS_PULL_REQUEST", "TRAVIS_PULL_REQUEST_BRANCH", "TRAVIS_BRANCH", "TRAVIS_PULL_REQUEST_SHA"},
+	// buildkite
+	{"BUILDKITE_PULL_REQUEST", "BUILDKITE_BRANCH", "BUILDKITE_PULL_REQUEST_BASE_BRANCH", "BUILDKITE_COMMIT"},
+	// drone
+	{"DRONE_PULL_REQUEST", "DRONE_SOURCE_BRANCH", "DRONE_TARGET_BRANCH", "DRONE_COMMIT_SHA"},
+	// circleci only knows the url of the pull request
+	{"CIRCLE_PULL_REQUEST", "CIRCLE_BRANCH", "", "CIRCLE_SHA1"},
+}
+
+// pullRefs are the refs hosting services keep for pull requests, like
+// refs/pull/123/head or refs/remotes/origin/merge-requests/123/head
+var pullRefs = regexp.MustCompile(`(?:^|/)(?:pull|merge-requests)/(\d+)/(head|merge)$`)
+
+// getPullRequest finds the pull request of the commit, from the CI
+// environment when describing HEAD and from the pull request refs otherwise
+func (c *Collector) getPullRequest(ctx context.Context, commit *GitCommit) (*GitPullRequest, error) {
+	var pr *GitPullRequest
+	if c.Ref == "" {
+		pr = pullRequestFromEnv()
+	}
+	if pr == nil {
+		var err error
+		if pr, err = c.pullRequestFromRefs(ctx, commit); err != nil || pr == nil {
+			return nil, err
+		}
+	}
+
+	if pr.HeadSHA == "" {
+		pr.HeadSHA = commit.LongHash
+	}
+	pr.HeadShortSHA = pr.HeadSHA
+	if short, err := c.git(ctx, "rev-parse", "--short", pr.HeadSHA); err == nil {
+		pr.HeadShortSHA = strings.TrimSpace(short)
+	} else if len(pr.HeadSHA) > 7 {
+		pr.HeadShortSHA = pr.HeadSHA[:7]
+	}
+	return pr, nil
+}
+
+func pullRequestFromEnv() *GitPullRequest {
+	if pr := githubPullRequest(); pr != nil {
+		return pr
+	}
+
+	for _, env := range pullRequestEnv {
+		number := os.Getenv(env.number)
+		if number == "" || number == "false" {
+			continue
+		}
+		pr := &GitPullRequest{
+			// the circleci url ends in the number
+			Number:       path.Base(number),
+			SourceBranch: strings.TrimPrefix(os.Getenv(env.source), "refs/heads/"),
+			HeadSHA:      os.Getenv(env.head),
+		}
+		if env.target != "" {
+			pr.TargetBranch = strings.TrimPrefix(os.Getenv(env.target), "refs/heads/")
+		}
+		return pr
+	}
+	return nil
+}
+
+// githubPullRequest reads the pull request of a github actions run, the head
+// commit is only in the event payload as the checkout is a merge commit
+func githubPullRequest() *GitPullRequest {
+	m := pullRefs.FindStringSubmatch(os.Getenv("GITHUB_REF"))
+	if m == nil || !strings.HasPrefix(os.Getenv("GITHUB_EVENT_NAME"), "pull_request") {
+		return nil
+	}
+
+	pr := &GitPullRequest{
+		Number:       m[1],
+		SourceBranch: os.Getenv("GITHUB_HEAD_REF"),
+		TargetBranch: os.Getenv("GITHUB_BASE_REF"),
+	}
+	var event struct {
+		PullRequest struct {
+			Head struct {
+				SHA string `json:"sha"`
+			} `json:"head"`
+		} `json:"pull_request"`
+	}
+	if data, err := ioutil.ReadFile(os.Getenv("GITHUB_EVENT_PATH")); err == nil && json.Unmarshal(data, &event) == nil {
+		pr.HeadSHA = event.PullRequest.Head.SHA
+	}
+	return pr
+}
+
+// pullRequestFromRefs looks for a pull request ref pointing at the commit, a
+// merge ref's head is the second parent of the merge commit
+func (c *Collector) pullRequestFromRefs(ctx context.Context, commit *GitCommit) (*GitPullRequest, error) {
+	if m := pullRefs.FindStringSubmatch(c.Ref); m != nil {
+		return c.pullRequestFor(ctx, m, commit), nil
+	}
+
+	out, err := c.git(ctx, "for-each-ref", "--points-at", commit.LongHash, "--format=%(refname)", "refs/pull", "refs/merge-requests", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range strings.Fields(out) {
+		if m := pullRefs.FindStringSubmatch(ref); m != nil {
+			return c.pullRequestFor(ctx, m, commit), nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *Collector) pullRequestFor(ctx context.Context, m []string, commit *GitCommit) *GitPullRequest {
+	pr := &GitPullRequest{Number: m[1]}
+	if m[2] == "merge" {
+		if head, err := c.git(ctx, "rev-parse", "--verify", "--quiet", commit.LongHash+"^2"); err == nil {
+			pr.HeadSHA = strings.TrimSpace(head)
+		}
+	}
+	return pr
+}
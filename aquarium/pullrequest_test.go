@@ -0,0 +1,161 @@
+package aquarium
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withEnv clears the variables of every CI system and sets env, the returned
+// func restores the environment
+func withEnv(env map[string]string) func() {
+	names := []string{"GITHUB_REF", "GITHUB_EVENT_NAME", "GITHUB_HEAD_REF", "GITHUB_BASE_REF", "GITHUB_EVENT_PATH"}
+	for _, e := range pullRequestEnv {
+		names = append(names, e.number, e.source, e.target, e.head)
+	}
+	previous := map[string]*string{}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			previous[name] = &value
+		} else {
+			previous[name] = nil
+		}
+		os.Unsetenv(name)
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+		for name, value := range previous {
+			if value != nil {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+func TestPullRequestFromEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	event := filepath.Join(dir, "event.json")
+	if err := ioutil.WriteFile(event, []byte(`{"pull_request":{"head":{"sha":"abc123"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want *GitPullRequest
+	}{
+		{"none", nil, nil},
+		{"github", map[string]string{
+			"GITHUB_REF":        "refs/pull/12/merge",
+			"GITHUB_EVENT_NAME": "pull_request",
+			"GITHUB_HEAD_REF":   "feature",
+			"GITHUB_BASE_REF":   "main",
+			"GITHUB_EVENT_PATH": event,
+		}, &GitPullRequest{Number: "12", SourceBranch: "feature", TargetBranch: "main", HeadSHA: "abc123"}},
+		{"github push", map[string]string{
+			"GITHUB_REF":        "refs/heads/main",
+			"GITHUB_EVENT_NAME": "push",
+		}, nil},
+		{"gitlab", map[string]string{
+			"CI_MERGE_REQUEST_IID":                "7",
+			"CI_MERGE_REQUEST_SOURCE_BRANCH_NAME": "feature",
+			"CI_MERGE_REQUEST_TARGET_BRANCH_NAME": "main",
+			"CI_MERGE_REQUEST_SOURCE_BRANCH_SHA":  "abc123",
+		}, &GitPullRequest{Number: "7", SourceBranch: "feature", TargetBranch: "main", HeadSHA: "abc123"}},
+		{"bitbucket", map[string]string{
+			"BITBUCKET_PR_ID":                 "3",
+			"BITBUCKET_BRANCH":                "feature",
+			"BITBUCKET_PR_DESTINATION_BRANCH": "main",
+			"BITBUCKET_COMMIT":                "abc123",
+		}, &GitPullRequest{Number: "3", SourceBranch: "feature", TargetBranch: "main", HeadSHA: "abc123"}},
+		{"azure", map[string]string{
+			"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER": "42",
+			"SYSTEM_PULLREQUEST_SOURCEBRANCH":      "refs/heads/feature",
+			"SYSTEM_PULLREQUEST_TARGETBRANCH":      "refs/heads/main",
+			"SYSTEM_PULLREQUEST_SOURCECOMMITID":    "abc123",
+		}, &GitPullRequest{Number: "42", SourceBranch: "feature", TargetBranch: "main", HeadSHA: "abc123"}},
+		{"jenkins", map[string]string{
+			"CHANGE_ID":     "5",
+			"CHANGE_BRANCH": "feature",
+			"CHANGE_TARGET": "main",
+			"GIT_COMMIT":    "abc123",
+		}, &GitPullRequest{Number: "5", SourceBranch: "feature", TargetBranch: "main", HeadSHA: "abc123"}},
+		{"travis", map[string]string{
+			"TRAVIS_PULL_REQUEST":        "9",
+			"TRAVIS_PULL_REQUEST_BRANCH": "feature",
+			"TRAVIS_BRANCH":              "main",
+			"TRAVIS_PULL_REQUEST_SHA":    "abc123",
+		}, &GitPullRequest{Number: "9", SourceBranch: "feature", TargetBranch: "main", HeadSHA: "abc123"}},
+		{"travis push", map[string]string{
+			"TRAVIS_PULL_REQUEST": "false",
+			"TRAVIS_BRANCH":       "main",
+		}, nil},
+		{"buildkite", map[string]string{
+			"BUILDKITE_PULL_REQUEST":             "11",
+			"BUILDKITE_BRANCH":                   "feature",
+			"BUILDKITE_PULL_REQUEST_BASE_BRANCH": "main",
+			"BUILDKITE_COMMIT":                   "abc123",
+		}, &GitPullRequest{Number: "11", SourceBranch: "feature", TargetBranch: "main", HeadSHA: "abc123"}},
+		{"buildkite push", map[string]string{
+			"BUILDKITE_PULL_REQUEST": "false",
+			"BUILDKITE_BRANCH":       "main",
+		}, nil},
+		{"drone", map[string]string{
+			"DRONE_PULL_REQUEST":  "4",
+			"DRONE_SOURCE_BRANCH": "feature",
+			"DRONE_TARGET_BRANCH": "main",
+			"DRONE_COMMIT_SHA":    "abc123",
+		}, &GitPullRequest{Number: "4", SourceBranch: "feature", TargetBranch: "main", HeadSHA: "abc123"}},
+		{"circleci", map[string]string{
+			"CIRCLE_PULL_REQUEST": "https://github.com/srizzling/aquarium/pull/8",
+			"CIRCLE_BRANCH":       "feature",
+			"CIRCLE_SHA1":         "abc123",
+		}, &GitPullRequest{Number: "8", SourceBranch: "feature", HeadSHA: "abc123"}},
+	}
+	for _, test := range tests {
+		restore := withEnv(test.env)
+		got := pullRequestFromEnv()
+		restore()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPullRefs(t *testing.T) {
+	tests := []struct {
+		ref, number, kind string
+	}{
+		{"refs/pull/123/head", "123", "head"},
+		{"refs/pull/123/merge", "123", "merge"},
+		{"refs/remotes/origin/merge-requests/7/head", "7", "head"},
+		{"pull/5/merge", "5", "merge"},
+		{"refs/heads/pull/5", "", ""},
+		{"refs/heads/main", "", ""},
+		{"refs/pull/abc/head", "", ""},
+	}
+	for _, test := range tests {
+		m := pullRefs.FindStringSubmatch(test.ref)
+		var number, kind string
+		if m != nil {
+			number, kind = m[1], m[2]
+		}
+		if number != test.number || kind != test.kind {
+			t.Errorf("%s: got %q %q, want %q %q", test.ref, number, kind, test.number, test.kind)
+		}
+	}
+}
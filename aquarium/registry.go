@@ -0,0 +1,789 @@
+package aquarium
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestMediaTypes is everything aquarium can retag, single platform
+// manifests as well as multi platform lists and indexes
+var manifestMediaTypes = []string{
+	mediaTypeDockerManifest,
+	mediaTypeDockerManifestList,
+	mediaTypeOCIManifest,
+	mediaTypeOCIIndex,
+}
+
+func isManifestList(mediaType string) bool {
+	return mediaType == mediaTypeDockerManifestList || mediaType == mediaTypeOCIIndex
+}
+
+// ImageRef is a parsed image reference, with docker hub names normalized
+type ImageRef struct {
+	Host       string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image reference the way the docker cli does, the
+// first path component is only a registry host when it looks like one
+func ParseReference(ref string) (*ImageRef, error) {
+	named, err := reference.ParseNamed(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %q: %v", ref, err)
+	}
+
+	r := &ImageRef{Host: "docker.io", Repository: named.Name()}
+	parts := strings.SplitN(named.Name(), "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.Host, r.Repository = parts[0], parts[1]
+	}
+	if r.Host == "docker.io" && !strings.Contains(r.Repository, "/") {
+		r.Repository = "library/" + r.Repository
+	}
+
+	if tagged, ok := named.(reference.NamedTagged); ok {
+		r.Tag = tagged.Tag()
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		r.Digest = canonical.Digest().String()
+	}
+	return r, nil
+}
+
+// Name is the repository including the registry host
+func (r *ImageRef) Name() string {
+	return r.Host + "/" + r.Repository
+}
+
+// Reference is the tag or digest part used in manifest urls
+func (r *ImageRef) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	if r.Tag != "" {
+		return r.Tag
+	}
+	return "latest"
+}
+
+func (r *ImageRef) String() string {
+	if r.Digest != "" {
+		return r.Name() + "@" + r.Digest
+	}
+	return r.Name() + ":" + r.Reference()
+}
+
+// registryClient is a minimal Registry HTTP API v2 client, it handles basic
+// and bearer token auth challenges with the credentials of `docker login`
+type registryClient struct {
+	client *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string
+	// basic are the credentials of hosts that asked for basic auth, they are
+	// sent up front from then on
+	basic map[string]types.AuthConfig
+}
+
+func newRegistryClient() *registryClient {
+	return &registryClient{
+		client: http.DefaultClient,
+		tokens: map[string]string{},
+		basic:  map[string]types.AuthConfig{},
+	}
+}
+
+func (r *registryClient) url(ref *ImageRef, format string, args ...interface{}) string {
+	host, scheme := ref.Host, "https"
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s", scheme, host, ref.Repository, fmt.Sprintf(format, args...))
+}
+
+func (r *registryClient) do(ctx context.Context, ref *ImageRef, req *http.Request, expected ...int) (*http.Response, error) {
+	actions := "pull"
+	if req.Method != "GET" && req.Method != "HEAD" {
+		actions = "pull,push"
+	}
+	tokenKey := ref.Host + "/" + ref.Repository + ":" + actions
+
+	r.mu.Lock()
+	token := r.tokens[tokenKey]
+	basic, hasBasic := r.basic[req.URL.Host]
+	r.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if hasBasic {
+		req.SetBasicAuth(basic.Username, basic.Password)
+	}
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	// a streamed body is gone after the first attempt and can't be sent
+	// again, uploads authenticate on the POST opening them instead
+	rewindable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if resp.StatusCode == http.StatusUnauthorized && rewindable {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		creds, err := loadCredentials(ref.Host)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.authorize(ctx, req, challenge, creds, tokenKey, "repository:"+ref.Repository+":"+actions); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		if resp, err = r.client.Do(req.WithContext(ctx)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, &registryError{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// registryError is a response with an unexpected status code
+type registryError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *registryError) Error() string {
+	return fmt.Sprintf("%s %s: %s %s", e.Method, e.URL, e.Status, e.Body)
+}
+
+// isNotFound reports whether err is a registry answering 404
+func isNotFound(err error) bool {
+	rerr, ok := err.(*registryError)
+	return ok && rerr.StatusCode == http.StatusNotFound
+}
+
+// authorize answers an auth challenge by adding credentials to req, for
+// bearer challenges a token is fetched from the realm and cached
+func (r *registryClient) authorize(ctx context.Context, req *http.Request, challenge string, creds types.AuthConfig, tokenKey, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if creds.Username == "" {
+			return fmt.Errorf("%s requires credentials, run docker login", req.URL.Host)
+		}
+		r.mu.Lock()
+		r.basic[req.URL.Host] = creds
+		r.mu.Unlock()
+		req.SetBasicAuth(creds.Username, creds.Password)
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("%s: unsupported auth challenge %q", req.URL.Host, challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("%s: invalid token realm in %q", req.URL.Host, challenge)
+	}
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	tokenReq, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if creds.Username != "" {
+		tokenReq.SetBasicAuth(creds.Username, creds.Password)
+	} else if creds.IdentityToken != "" {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", creds.IdentityToken)
+		form.Set("service", params["service"])
+		form.Set("scope", scope)
+		form.Set("client_id", "aquarium")
+		tokenReq, err = http.NewRequest("POST", realm.Scheme+"://"+realm.Host+realm.Path, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := r.client.Do(tokenReq.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching registry token from %s: %s", realm.Host, resp.Status)
+	}
+
+	tokenResp := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return err
+	}
+	token := firstNonEmpty(tokenResp.Token, tokenResp.AccessToken)
+	if token == "" {
+		return fmt.Errorf("registry token response from %s has no token", realm.Host)
+	}
+
+	r.mu.Lock()
+	r.tokens[tokenKey] = token
+	r.mu.Unlock()
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its lower cased scheme
+// and parameters, e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	scheme := strings.ToLower(parts[0])
+	if len(parts) < 2 {
+		return scheme, params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+// getManifest fetches the manifest ref points at, returning its raw bytes,
+// media type and digest
+func (r *registryClient) getManifest(ctx context.Context, ref *ImageRef) ([]byte, string, string, error) {
+	req, err := http.NewRequest("GET", r.url(ref, "manifests/%s", ref.Reference()), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	resp, err := r.do(ctx, ref, req, http.StatusOK)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return body, manifestMediaType(resp.Header.Get("Content-Type"), body), digestOf(body), nil
+}
+
+// manifestMediaType prefers the content type the registry sent, falling back
+// to the mediaType field of the manifest itself
+func manifestMediaType(contentType string, manifest []byte) string {
+	if mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]); mediaType != "" && mediaType != "application/json" {
+		return mediaType
+	}
+	m := struct {
+		MediaType string `json:"mediaType"`
+	}{}
+	if err := json.Unmarshal(manifest, &m); err == nil && m.MediaType != "" {
+		return m.MediaType
+	}
+	return mediaTypeOCIManifest
+}
+
+// putManifest stores manifest under tag (or digest) in ref's repository
+func (r *registryClient) putManifest(ctx context.Context, ref *ImageRef, tag string, manifest []byte, mediaType string) (string, error) {
+	req, err := http.NewRequest("PUT", r.url(ref, "manifests/%s", tag), bytes.NewReader(manifest))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mediaType)
+
+	resp, err := r.do(ctx, ref, req, http.StatusCreated, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return digestOf(manifest), nil
+}
+
+// deleteManifest removes a tag, registries that only delete by digest refuse
+func (r *registryClient) deleteManifest(ctx context.Context, ref *ImageRef, reference string) error {
+	req, err := http.NewRequest("DELETE", r.url(ref, "manifests/%s", reference), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(ctx, ref, req, http.StatusAccepted, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (r *registryClient) getBlob(ctx context.Context, ref *ImageRef, digest string) ([]byte, error) {
+	req, err := http.NewRequest("GET", r.url(ref, "blobs/%s", digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.do(ctx, ref, req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// putBlob uploads blob in a single request (monolithic upload)
+func (r *registryClient) putBlob(ctx context.Context, ref *ImageRef, blob []byte) (string, error) {
+	digest := digestOf(blob)
+	return digest, r.uploadBlob(ctx, ref, digest, bytes.NewReader(blob), int64(len(blob)))
+}
+
+func (r *registryClient) uploadBlob(ctx context.Context, ref *ImageRef, digest string, blob io.Reader, size int64) error {
+	req, err := http.NewRequest("POST", r.url(ref, "blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(ctx, ref, req, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return r.finishUpload(ctx, ref, req.URL, resp.Header.Get("Location"), digest, blob, size)
+}
+
+// finishUpload sends the blob to the upload session at location
+func (r *registryClient) finishUpload(ctx context.Context, ref *ImageRef, base *url.URL, location, digest string, blob io.Reader, size int64) error {
+	uploadURL, err := base.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := uploadURL.Query()
+	query.Set("digest", digest)
+	uploadURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("PUT", uploadURL.String(), blob)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := r.do(ctx, ref, req, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// hasBlob reports whether the repository of ref already contains digest
+func (r *registryClient) hasBlob(ctx context.Context, ref *ImageRef, digest string) (bool, error) {
+	req, err := http.NewRequest("HEAD", r.url(ref, "blobs/%s", digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := r.do(ctx, ref, req, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// copyBlob makes digest of src available in dst. Within one registry the blob
+// is mounted across repositories, otherwise it is streamed through aquarium.
+func (r *registryClient) copyBlob(ctx context.Context, src, dst *ImageRef, digest string) error {
+	exists, err := r.hasBlob(ctx, dst, digest)
+	if err != nil || exists {
+		return err
+	}
+
+	var location string
+	var base *url.URL
+	if src.Host == dst.Host {
+		query := url.Values{}
+		query.Set("mount", digest)
+		query.Set("from", src.Repository)
+		req, err := http.NewRequest("POST", r.url(dst, "blobs/uploads/?%s", query.Encode()), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := r.do(ctx, dst, req, http.StatusCreated, http.StatusAccepted)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusCreated {
+			return nil
+		}
+		// the registry could not mount, it opened a regular upload instead
+		location, base = resp.Header.Get("Location"), req.URL
+	}
+
+	req, err := http.NewRequest("GET", r.url(src, "blobs/%s", digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(ctx, src, req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if location == "" {
+		return r.uploadBlob(ctx, dst, digest, resp.Body, resp.ContentLength)
+	}
+	return r.finishUpload(ctx, dst, base, location, digest, resp.Body, resp.ContentLength)
+}
+
+// copyImage copies the manifest src points at, including every blob and, for
+// manifest lists, every platform manifest, into the repository of dst. The
+// manifest is stored by digest, tagging it is up to the caller.
+func (r *registryClient) copyImage(ctx context.Context, src, dst *ImageRef) ([]byte, string, error) {
+	manifestData, mediaType, digest, err := r.getManifest(ctx, src)
+	if err != nil {
+		return nil, "", err
+	}
+
+	manifest := struct {
+		Config    *struct{ Digest string }
+		Layers    []struct{ Digest string }
+		Manifests []struct{ Digest string }
+	}{}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, "", err
+	}
+
+	if isManifestList(mediaType) {
+		for _, m := range manifest.Manifests {
+			child := *src
+			child.Tag, child.Digest = "", m.Digest
+			if _, _, err := r.copyImage(ctx, &child, dst); err != nil {
+				return nil, "", err
+			}
+		}
+	} else {
+		if manifest.Config != nil {
+			if err := r.copyBlob(ctx, src, dst, manifest.Config.Digest); err != nil {
+				return nil, "", err
+			}
+		}
+		for _, layer := range manifest.Layers {
+			if err := r.copyBlob(ctx, src, dst, layer.Digest); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	if _, err := r.putManifest(ctx, dst, digest, manifestData, mediaType); err != nil {
+		return nil, "", err
+	}
+	return manifestData, mediaType, nil
+}
+
+// registryBackend applies tags to images that already live in a registry,
+// nothing is pulled to the machine aquarium runs on
+type registryBackend struct {
+	registry *registryClient
+
+	mu sync.Mutex
+	// the manifests Label stored that did not exist yet, by labeled reference
+	labeled map[string][]*ImageRef
+}
+
+// NewRegistryTagger tags images in their registry, with the credentials of
+// the docker config
+func NewRegistryTagger() (Tagger, error) {
+	return &registryBackend{registry: newRegistryClient(), labeled: map[string][]*ImageRef{}}, nil
+}
+
+// Resolve turns the image to tag into a full reference: a reference is used
+// as is, a bare digest or tag is looked up in the repository being tagged
+func (b *registryBackend) Resolve(ctx context.Context, source, name string) (string, error) {
+	if strings.Contains(source, "/") {
+		return source, nil
+	}
+
+	target, err := ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	target.Tag, target.Digest = "", ""
+	if strings.HasPrefix(source, "sha256:") {
+		target.Digest = source
+	} else {
+		target.Tag = source
+	}
+	return target.String(), nil
+}
+
+func (b *registryBackend) Inspect(ctx context.Context, source string) (*ImageInfo, error) {
+	src, err := ParseReference(source)
+	if err != nil {
+		return nil, err
+	}
+	_, _, digest, err := b.registry.getManifest(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageInfo{
+		ID:      digest,
+		Digests: []string{src.Name() + "@" + digest},
+	}, nil
+}
+
+// Label rewrites the image config with the labels added, uploads it and
+// stores a manifest pointing at it, referenced by digest. For manifest lists
+// and indexes every platform image is labeled and a new list is stored.
+func (b *registryBackend) Label(ctx context.Context, source string, labels map[string]string) (string, error) {
+	src, err := ParseReference(source)
+	if err != nil {
+		return "", err
+	}
+
+	manifestData, mediaType, _, err := b.registry.getManifest(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	var created []*ImageRef
+	if isManifestList(mediaType) {
+		manifestData, err = b.labelList(ctx, src, manifestData, labels, &created)
+	} else {
+		manifestData, err = b.labelManifest(ctx, src, manifestData, labels)
+	}
+	if err != nil {
+		return "", fmt.Errorf("labeling %s: %v", src, err)
+	}
+
+	digest, err := b.putNewManifest(ctx, src, manifestData, mediaType, &created)
+	if err != nil {
+		return "", err
+	}
+	labeled := src.Name() + "@" + digest
+
+	b.mu.Lock()
+	b.labeled[labeled] = created
+	b.mu.Unlock()
+	return labeled, nil
+}
+
+// putNewManifest stores manifestData by digest, adding it to created when the
+// repository did not have it yet
+func (b *registryBackend) putNewManifest(ctx context.Context, repo *ImageRef, manifestData []byte, mediaType string, created *[]*ImageRef) (string, error) {
+	ref := *repo
+	ref.Tag, ref.Digest = "", digestOf(manifestData)
+	_, _, _, err := b.registry.getManifest(ctx, &ref)
+	missing := isNotFound(err)
+	if err != nil && !missing {
+		return "", err
+	}
+	if _, err := b.registry.putManifest(ctx, &ref, ref.Digest, manifestData, mediaType); err != nil {
+		return "", err
+	}
+	if missing {
+		*created = append(*created, &ref)
+	}
+	return ref.Digest, nil
+}
+
+// Unlabel deletes the manifests Label stored, the list before its images.
+// The config blobs are left to the garbage collection of the registry.
+func (b *registryBackend) Unlabel(ctx context.Context, labeled string) error {
+	b.mu.Lock()
+	created := b.labeled[labeled]
+	delete(b.labeled, labeled)
+	b.mu.Unlock()
+
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := b.registry.deleteManifest(ctx, created[i], created[i].Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *registryBackend) labelManifest(ctx context.Context, src *ImageRef, manifestData []byte, labels map[string]string) ([]byte, error) {
+	return relabelManifest(manifestData, labels,
+		func(digest string) ([]byte, error) { return b.registry.getBlob(ctx, src, digest) },
+		func(blob []byte) (string, error) { return b.registry.putBlob(ctx, src, blob) },
+	)
+}
+
+// labelList labels every image of a manifest list and returns the list
+// pointing at the labeled images
+func (b *registryBackend) labelList(ctx context.Context, src *ImageRef, listData []byte, labels map[string]string, created *[]*ImageRef) ([]byte, error) {
+	list := map[string]json.RawMessage{}
+	if err := json.Unmarshal(listData, &list); err != nil {
+		return nil, err
+	}
+	var descriptors []map[string]interface{}
+	if err := json.Unmarshal(list["manifests"], &descriptors); err != nil {
+		return nil, err
+	}
+
+	for _, desc := range descriptors {
+		digest, _ := desc["digest"].(string)
+		mediaType, _ := desc["mediaType"].(string)
+		if isManifestList(mediaType) {
+			return nil, fmt.Errorf("nested manifest list %s is not supported", digest)
+		}
+		if annotations, ok := desc["annotations"].(map[string]interface{}); ok && annotations["vnd.docker.reference.type"] != nil {
+			// attestations and other artifacts attached to the image are no images
+			continue
+		}
+
+		child := *src
+		child.Tag, child.Digest = "", digest
+		manifestData, childType, _, err := b.registry.getManifest(ctx, &child)
+		if err != nil {
+			return nil, err
+		}
+		if manifestData, err = b.labelManifest(ctx, src, manifestData, labels); err != nil {
+			return nil, err
+		}
+		newDigest, err := b.putNewManifest(ctx, src, manifestData, childType, created)
+		if err != nil {
+			return nil, err
+		}
+		desc["digest"] = newDigest
+		desc["size"] = len(manifestData)
+	}
+
+	var err error
+	if list["manifests"], err = json.Marshal(descriptors); err != nil {
+		return nil, err
+	}
+	return json.Marshal(list)
+}
+
+func (b *registryBackend) Tag(ctx context.Context, source, ref string) error {
+	target, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	src, err := ParseReference(source)
+	if err != nil {
+		return err
+	}
+	if src.Name() != target.Name() {
+		return fmt.Errorf("registry backend can only tag within a repository, %s is not in %s", src, target.Name())
+	}
+
+	manifest, mediaType, _, err := b.registry.getManifest(ctx, src)
+	if err != nil {
+		return err
+	}
+	_, err = b.registry.putManifest(ctx, target, target.Reference(), manifest, mediaType)
+	return err
+}
+
+// Current returns the digest reference ref points at, empty when the tag
+// does not exist yet
+func (b *registryBackend) Current(ctx context.Context, ref string) (string, error) {
+	target, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	_, _, digest, err := b.registry.getManifest(ctx, target)
+	if isNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return target.Name() + "@" + digest, nil
+}
+
+func (b *registryBackend) Untag(ctx context.Context, ref string) error {
+	target, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	return b.registry.deleteManifest(ctx, target, target.Reference())
+}
+
+// Push has nothing to upload, tags are created in the registry directly
+func (b *registryBackend) Push(ctx context.Context, ref string) (string, error) {
+	target, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	_, _, digest, err := b.registry.getManifest(ctx, target)
+	return digest, err
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
@@ -0,0 +1,371 @@
+package aquarium
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry is a registry stand-in keeping manifests and blobs in memory,
+// auth is "", "basic" or "bearer" with the credentials user:secret
+type fakeRegistry struct {
+	auth   string
+	server *httptest.Server
+
+	mu           sync.Mutex
+	manifests    map[string]fakeManifest
+	blobs        map[string][]byte
+	uploads      int
+	unauthorized int
+	tokens       int
+}
+
+type fakeManifest struct {
+	data      []byte
+	mediaType string
+}
+
+// newFakeRegistry starts a registry, the caller closes f.server
+func newFakeRegistry(auth string) *fakeRegistry {
+	f := &fakeRegistry{
+		auth:      auth,
+		manifests: map[string]fakeManifest{},
+		blobs:     map[string][]byte{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeRegistry) host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+func (f *fakeRegistry) ref(t *testing.T, ref string) *ImageRef {
+	r, err := ParseReference(f.host() + "/" + ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// addManifest stores a manifest by digest and, when tag is set, by tag
+func (f *fakeRegistry) addManifest(repo, tag string, data []byte, mediaType string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	digest := digestOf(data)
+	f.manifests[repo+"@"+digest] = fakeManifest{data, mediaType}
+	if tag != "" {
+		f.manifests[repo+":"+tag] = fakeManifest{data, mediaType}
+	}
+	return digest
+}
+
+func (f *fakeRegistry) addBlob(data []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	digest := digestOf(data)
+	f.blobs[digest] = data
+	return digest
+}
+
+func (f *fakeRegistry) authorized(w http.ResponseWriter, r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	switch {
+	case f.auth == "basic" && ok && user == "user" && pass == "secret":
+		return true
+	case f.auth == "bearer" && r.Header.Get("Authorization") == "Bearer token":
+		return true
+	case f.auth == "":
+		return true
+	}
+
+	f.unauthorized++
+	if f.auth == "basic" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, f.server.URL))
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+func (f *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.tokens++
+		json.NewEncoder(w).Encode(map[string]string{"token": "token"})
+		return
+	}
+	if !f.authorized(w, r) {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		key := parts[0] + ":" + parts[1]
+		if strings.HasPrefix(parts[1], "sha256:") {
+			key = parts[0] + "@" + parts[1]
+		}
+		switch r.Method {
+		case "GET", "HEAD":
+			m, ok := f.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", m.mediaType)
+			w.Write(m.data)
+		case "PUT":
+			data, _ := ioutil.ReadAll(r.Body)
+			m := fakeManifest{data, r.Header.Get("Content-Type")}
+			f.manifests[key] = m
+			f.manifests[parts[0]+"@"+digestOf(data)] = m
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			delete(f.manifests, key)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	case strings.HasSuffix(path, "/blobs/uploads/"):
+		if digest := r.URL.Query().Get("mount"); digest != "" && f.blobs[digest] != nil {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		f.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%supload-%d", path, f.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(path, "/blobs/uploads/"):
+		data, _ := ioutil.ReadAll(r.Body)
+		if digest := r.URL.Query().Get("digest"); digest != digestOf(data) {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		f.blobs[digestOf(data)] = data
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		blob, ok := f.blobs[path[strings.LastIndex(path, "/")+1:]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		if r.Method == "GET" {
+			w.Write(blob)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// withCredentials points DOCKER_CONFIG at a config logged in to the hosts
+// until the returned func restores it
+func withCredentials(t *testing.T, hosts ...string) func() {
+	auths := map[string]map[string]string{}
+	for _, host := range hosts {
+		auths[host] = map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte("user:secret"))}
+	}
+	data, err := json.Marshal(map[string]interface{}{"auths": auths})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), data, 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	previous := os.Getenv("DOCKER_CONFIG")
+	os.Setenv("DOCKER_CONFIG", dir)
+	return func() {
+		os.Setenv("DOCKER_CONFIG", previous)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{`Basic realm="registry"`, "basic", map[string]string{"realm": "registry"}},
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/alpine:pull"},
+		},
+		{`Bearer realm=https://auth.example.com/token, service=example`, "bearer", map[string]string{"realm": "https://auth.example.com/token", "service": "example"}},
+		{`Basic`, "basic", map[string]string{}},
+	}
+	for _, tt := range tests {
+		scheme, params := parseChallenge(tt.header)
+		if scheme != tt.scheme || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseChallenge(%q) = %q, %v, want %q, %v", tt.header, scheme, params, tt.scheme, tt.params)
+		}
+	}
+}
+
+func TestRegistryManifestRoundTrip(t *testing.T) {
+	registry := newFakeRegistry("")
+	defer registry.server.Close()
+	client := newRegistryClient()
+	ctx := context.Background()
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"` + mediaTypeOCIManifest + `"}`)
+	digest, err := client.putManifest(ctx, registry.ref(t, "app"), "1.0", manifest, mediaTypeOCIManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, mediaType, got, err := client.getManifest(ctx, registry.ref(t, "app:1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(manifest) || mediaType != mediaTypeOCIManifest || got != digest {
+		t.Errorf("getManifest = %s, %s, %s, want %s, %s, %s", data, mediaType, got, manifest, mediaTypeOCIManifest, digest)
+	}
+
+	if _, _, _, err := client.getManifest(ctx, registry.ref(t, "app:missing")); !isNotFound(err) {
+		t.Errorf("getManifest of a missing tag = %v, want a not found error", err)
+	}
+}
+
+func TestRegistryBearerToken(t *testing.T) {
+	registry := newFakeRegistry("bearer")
+	defer registry.server.Close()
+	defer withCredentials(t, registry.host())()
+	registry.addManifest("app", "1.0", []byte(`{}`), mediaTypeDockerManifest)
+	client := newRegistryClient()
+
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := client.getManifest(context.Background(), registry.ref(t, "app:1.0")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if registry.tokens != 1 || registry.unauthorized != 1 {
+		t.Errorf("fetched %d tokens after %d challenges, want the token fetched once and cached", registry.tokens, registry.unauthorized)
+	}
+}
+
+func TestRegistryBasicAuth(t *testing.T) {
+	registry := newFakeRegistry("basic")
+	defer registry.server.Close()
+	defer withCredentials(t, registry.host())()
+	registry.addManifest("app", "1.0", []byte(`{}`), mediaTypeDockerManifest)
+	client := newRegistryClient()
+
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := client.getManifest(context.Background(), registry.ref(t, "app:1.0")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if registry.unauthorized != 1 {
+		t.Errorf("got %d challenges, want basic credentials sent up front after the first", registry.unauthorized)
+	}
+}
+
+func TestRegistryAuthorizeWithoutCredentials(t *testing.T) {
+	registry := newFakeRegistry("basic")
+	defer registry.server.Close()
+	defer withCredentials(t)()
+
+	_, _, _, err := newRegistryClient().getManifest(context.Background(), registry.ref(t, "app:1.0"))
+	if err == nil || !strings.Contains(err.Error(), "run docker login") {
+		t.Errorf("got %v, want an error asking for docker login", err)
+	}
+}
+
+// TestRegistryCopyImageBasicAuth streams blobs between registries, the upload
+// can't be sent twice so it has to be authenticated the first time
+func TestRegistryCopyImageBasicAuth(t *testing.T) {
+	src := newFakeRegistry("basic")
+	defer src.server.Close()
+	dst := newFakeRegistry("basic")
+	defer dst.server.Close()
+	defer withCredentials(t, src.host(), dst.host())()
+
+	config := src.addBlob([]byte(`{"config":{}}`))
+	layer := src.addBlob([]byte("layer"))
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"digest":%q},"layers":[{"digest":%q}]}`,
+		mediaTypeDockerManifest, config, layer))
+	digest := src.addManifest("app", "1.0", manifest, mediaTypeDockerManifest)
+
+	if _, _, err := newRegistryClient().copyImage(context.Background(), src.ref(t, "app:1.0"), dst.ref(t, "app")); err != nil {
+		t.Fatal(err)
+	}
+	for _, blob := range []string{config, layer} {
+		if dst.blobs[blob] == nil {
+			t.Errorf("blob %s was not copied", blob)
+		}
+	}
+	if _, ok := dst.manifests["app@"+digest]; !ok {
+		t.Errorf("manifest %s was not copied", digest)
+	}
+}
+
+func TestRegistryTagManifestList(t *testing.T) {
+	for _, mediaType := range []string{mediaTypeDockerManifestList, mediaTypeOCIIndex} {
+		t.Run(mediaType, func(t *testing.T) {
+			registry := newFakeRegistry("bearer")
+			defer registry.server.Close()
+			defer withCredentials(t, registry.host())()
+			child := registry.addManifest("app", "", []byte(`{"schemaVersion":2}`), mediaTypeOCIManifest)
+			list := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[{"digest":%q}]}`, mediaType, child))
+			digest := registry.addManifest("app", "", list, mediaType)
+
+			tagger, err := NewRegistryTagger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			source := registry.host() + "/app@" + digest
+			if err := tagger.Tag(context.Background(), source, registry.host()+"/app:1.0"); err != nil {
+				t.Fatal(err)
+			}
+
+			tagged, ok := registry.manifests["app:1.0"]
+			if !ok || string(tagged.data) != string(list) || tagged.mediaType != mediaType {
+				t.Errorf("app:1.0 = %s (%s), want the list %s (%s)", tagged.data, tagged.mediaType, list, mediaType)
+			}
+			current, err := tagger.Current(context.Background(), registry.host()+"/app:1.0")
+			if err != nil || current != source {
+				t.Errorf("Current = %q, %v, want %q", current, err, source)
+			}
+		})
+	}
+}
+
+func TestRegistryTagAcrossRepositories(t *testing.T) {
+	tagger, err := NewRegistryTagger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tagger.Tag(context.Background(), "localhost:5000/app@sha256:"+strings.Repeat("a", 64), "localhost:5000/other:1.0")
+	if err == nil {
+		t.Error("tagging into another repository succeeded, want an error")
+	}
+}
+
+func TestMain(m *testing.M) {
+	// the tests must not pick up the credentials of the machine they run on
+	os.Setenv("DOCKER_CONFIG", os.TempDir()+"/aquarium-test-no-config")
+	os.Exit(m.Run())
+}
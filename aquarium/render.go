@@ -0,0 +1,264 @@
+package aquarium
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/template"
+)
+
+// RenderImageName renders an image_names entry, the result must be a
+// repository without tag or digest
+func RenderImageName(nameTemplate string, tmplData *Metadata) (string, error) {
+	name, err := Render("image_name", nameTemplate, tmplData)
+	if err != nil {
+		return "", err
+	}
+	ref, err := ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	if ref.Tag != "" || ref.Digest != "" {
+		return "", fmt.Errorf("image name %s must be a repository without tag", name)
+	}
+	return name, nil
+}
+
+// validTag is the grammar of a tag in an image reference
+var validTag = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// RenderTag renders a tag_format entry, the result must be a valid tag or
+// empty, which means the tag does not apply, e.g.
+// {{ if .PullRequest }}pr-{{ .PullRequest.Number }}{{ end }}
+func RenderTag(tagTemplate string, tmplData *Metadata) (string, error) {
+	tag, err := Render("tag_template", tagTemplate, tmplData)
+	if err != nil {
+		return "", err
+	}
+	if tag = strings.TrimSpace(tag); tag == "" {
+		return "", nil
+	}
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("%q is not a valid tag", tag)
+	}
+	return tag, nil
+}
+
+// RenderTags returns the name:tag references for every tag template that
+// applies
+func RenderTags(name string, tmplData *Metadata, tagFormats []string) ([]string, error) {
+	var refs []string
+	for _, tagTemplate := range tagFormats {
+		tag, err := RenderTag(tagTemplate, tmplData)
+		if err != nil {
+			return nil, err
+		} else if tag == "" {
+			continue
+		}
+		ref := fmt.Sprintf("%s:%s", name, tag)
+		if _, err := ParseReference(ref); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// Render executes a single template string against the git metadata
+func Render(name, text string, tmplData *Metadata) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, tmplData); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// templateFuncs are the functions available to every template
+var templateFuncs = template.FuncMap{
+	"sanitize": sanitize,
+	// {{ .BuildTime | date "2006.01.02" }}, the layout is Go's reference time
+	"date": func(layout string, t time.Time) string { return t.Format(layout) },
+	"unix": func(t time.Time) int64 { return t.Unix() },
+	"utc":  func(t time.Time) time.Time { return t.UTC() },
+	// {{ .BuildTime | tz "Europe/Berlin" | date "15:04" }}
+	"tz": func(name string, t time.Time) (time.Time, error) {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return t, err
+		}
+		return t.In(loc), nil
+	},
+}
+
+var (
+	unsafeChars   = regexp.MustCompile(`[^a-z0-9._-]+`)
+	separatorRuns = regexp.MustCompile(`[._-]{2,}`)
+)
+
+// maxSanitizeLen is the longest tag a reference allows
+const maxSanitizeLen = 128
+
+// sanitize turns a value like a branch name into something valid both as a
+// repository path component and as a tag: lower case, every run of other
+// characters or of separators replaced by a single dash, no leading or
+// trailing separators and at most 128 characters,
+// e.g. feature/JIRA-12_x -> feature-jira-12_x, fix/a..b -> fix-a-b
+func sanitize(s string) string {
+	s = unsafeChars.ReplaceAllString(strings.ToLower(s), "-")
+	s = separatorRuns.ReplaceAllString(s, "-")
+	s = strings.Trim(s, "._-")
+	if len(s) > maxSanitizeLen {
+		s = strings.TrimRight(s[:maxSanitizeLen], "._-")
+	}
+	return s
+}
+
+// ociLabels are the org.opencontainers.image.* annotations aquarium can fill in
+// on its own, see https://github.com/opencontainers/image-spec/blob/master/annotations.md
+var ociLabels = []struct {
+	key      string
+	template string
+}{
+	{"org.opencontainers.image.revision", "{{ .Commit.LongHash }}"},
+	{"org.opencontainers.image.source", "{{ .Repo.URL }}"},
+	{"org.opencontainers.image.version", "{{ .Tag.Raw }}"},
+	{"org.opencontainers.image.created", "{{ .Created }}"},
+	// the release tag, only when it points at the commit being built
+	{"org.opencontainers.image.ref.name", "{{ range .Commit.Tags }}{{ if eq . $.Tag.Name }}{{ . }}{{ end }}{{ end }}"},
+	{"org.opencontainers.image.title", "{{ .Repo.Name }}"},
+	{"org.opencontainers.image.authors", "{{ if .Commit.AuthorEmail }}{{ .Commit.AuthorName }} <{{ .Commit.AuthorEmail }}>{{ end }}"},
+}
+
+// Labels renders the label_format entries (key=value) and, when enabled,
+// the built-in OCI label set. Entries in label_format override built-in labels
+// with the same key.
+func Labels(tmplData *Metadata, labelFormats []string, withOCI bool) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	if withOCI {
+		for _, l := range ociLabels {
+			value, err := Render(l.key, l.template, tmplData)
+			if err != nil {
+				return nil, err
+			}
+			// don't emit labels we have no data for
+			if value != "" {
+				labels[l.key] = value
+			}
+		}
+	}
+
+	if err := renderPairs(labels, "label", labelFormats, tmplData); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// BuildArgs renders the build_args entries (key=value)
+func BuildArgs(tmplData *Metadata, argFormats []string) (map[string]string, error) {
+	args := make(map[string]string)
+	if err := renderPairs(args, "build_arg", argFormats, tmplData); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// renderPairs renders key=value templates into pairs
+func renderPairs(pairs map[string]string, kind string, formats []string, tmplData *Metadata) error {
+	for _, pairTemplate := range formats {
+		pair, err := Render(kind+"_template", pairTemplate, tmplData)
+		if err != nil {
+			return err
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("%s %q is not in the key=value format", kind, pairTemplate)
+		}
+		pairs[strings.TrimSpace(parts[0])] = parts[1]
+	}
+	return nil
+}
+
+// relabelManifest stores a copy of the image config with labels merged in and
+// returns the image manifest pointing at it, blobs are read and written
+// through the given functions so any image store can share this
+func relabelManifest(manifestData []byte, labels map[string]string, getBlob func(string) ([]byte, error), putBlob func([]byte) (string, error)) ([]byte, error) {
+	manifest := map[string]json.RawMessage{}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, err
+	}
+	descriptor := map[string]interface{}{}
+	if raw, ok := manifest["config"]; ok {
+		if err := json.Unmarshal(raw, &descriptor); err != nil {
+			return nil, err
+		}
+	}
+	configDigest, _ := descriptor["digest"].(string)
+	if configDigest == "" {
+		return nil, errors.New("manifest has no image config to label")
+	}
+
+	configData, err := getBlob(configDigest)
+	if err != nil {
+		return nil, err
+	}
+	config, err := withLabels(configData, labels)
+	if err != nil {
+		return nil, err
+	}
+	newDigest, err := putBlob(config)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor["digest"] = newDigest
+	descriptor["size"] = len(config)
+	if manifest["config"], err = json.Marshal(descriptor); err != nil {
+		return nil, err
+	}
+	return json.Marshal(manifest)
+}
+
+// withLabels merges labels into the Labels of an image config blob, leaving
+// every other field untouched
+func withLabels(configData []byte, labels map[string]string) ([]byte, error) {
+	config := map[string]json.RawMessage{}
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return nil, err
+	}
+	runConfig := map[string]json.RawMessage{}
+	if raw, ok := config["config"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &runConfig); err != nil {
+			return nil, err
+		}
+	}
+	existing := map[string]string{}
+	if raw, ok := runConfig["Labels"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range labels {
+		existing[k] = v
+	}
+
+	var err error
+	if runConfig["Labels"], err = json.Marshal(existing); err != nil {
+		return nil, err
+	}
+	if config["config"], err = json.Marshal(runConfig); err != nil {
+		return nil, err
+	}
+	return json.Marshal(config)
+}
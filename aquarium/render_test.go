@@ -0,0 +1,75 @@
+package aquarium
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"main", "main"},
+		{"feature/JIRA-12_x", "feature-jira-12_x"},
+		{"feat/x_-y", "feat-x-y"},
+		{"fix/a..b", "fix-a-b"},
+		{"release/1.2", "release-1.2"},
+		{"a__b", "a-b"},
+		{"..Foo--", "foo"},
+		{"a//b", "a-b"},
+		{"Ünïcode ☃ branch", "n-code-branch"},
+		{"///", ""},
+		{strings.Repeat("ab", 100), strings.Repeat("ab", 64)},
+		{strings.Repeat("a", 127) + "-b", strings.Repeat("a", 127)},
+	}
+	for _, tt := range tests {
+		if got := sanitize(tt.in); got != tt.out {
+			t.Errorf("sanitize(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestSanitizedNamesAreValid(t *testing.T) {
+	for _, branch := range []string{"feat/x_-y", "fix/a..b", "a.-_b", "Weird__Branch..Name", strings.Repeat("x.", 100)} {
+		data := &Metadata{Branch: &GitBranch{Name: branch}}
+		if _, err := RenderImageName("registry.example.com/{{ .Branch.Name | sanitize }}/app", data); err != nil {
+			t.Errorf("image name for branch %q: %v", branch, err)
+		}
+		if _, err := RenderTag("{{ .Branch.Name | sanitize }}", data); err != nil {
+			t.Errorf("tag for branch %q: %v", branch, err)
+		}
+	}
+}
+
+func TestOCILabels(t *testing.T) {
+	data := &Metadata{
+		Tag:    &GitTag{Name: "v1.2.0", Raw: "1.2.0"},
+		Commit: &GitCommit{LongHash: "abc123", Tags: []string{"nightly", "v1.2.0"}},
+		Branch: &GitBranch{Name: "HEAD"},
+		Repo:   &GitRepo{Name: "app"},
+	}
+	labels, err := Labels(data, []string{"org.opencontainers.image.licenses=MIT"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"org.opencontainers.image.revision": "abc123",
+		"org.opencontainers.image.version":  "1.2.0",
+		"org.opencontainers.image.ref.name": "v1.2.0",
+		"org.opencontainers.image.title":    "app",
+		"org.opencontainers.image.licenses": "MIT",
+	}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("labels = %v, want %v", labels, want)
+	}
+
+	// a commit after the release has no reference name of its own
+	data.Commit.Tags = nil
+	if labels, err = Labels(data, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := labels["org.opencontainers.image.ref.name"]; ok {
+		t.Errorf("ref.name = %q for an untagged commit, want none", name)
+	}
+}
@@ -0,0 +1,111 @@
+package aquarium
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// journal remembers how to undo every operation of a run, so a run that
+// fails halfway can be rolled back instead of leaving some tags applied
+type journal struct {
+	mu   sync.Mutex
+	undo []undoOp
+}
+
+type undoOp struct {
+	description string
+	fn          func(context.Context) error
+}
+
+func (j *journal) record(description string, fn func(context.Context) error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.undo = append(j.undo, undoOp{description: description, fn: fn})
+}
+
+// rollback undoes the recorded operations, newest first, and reports the
+// ones that could not be undone
+func (j *journal) rollback(ctx context.Context) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var errs []error
+	for i := len(j.undo) - 1; i >= 0; i-- {
+		op := j.undo[i]
+		if err := op.fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", op.description, err))
+		} else {
+			errs = append(errs, nil)
+		}
+	}
+	j.undo = nil
+	return collectErrors(errs)
+}
+
+// abort rolls the run back after err and says how that went
+func (j *journal) abort(ctx context.Context, err error) error {
+	j.mu.Lock()
+	empty := len(j.undo) == 0
+	j.mu.Unlock()
+	if empty {
+		return err
+	}
+	if rollbackErr := j.rollback(ctx); rollbackErr != nil {
+		return fmt.Errorf("%v\n\nrolling back failed, the run is partially applied:\n%v", err, rollbackErr)
+	}
+	return fmt.Errorf("%v\n\nevery applied tag and labeled image was rolled back", err)
+}
+
+// tagWithUndo applies ref and records how to take it back: a new tag is
+// removed, a moved tag is pointed back at the image it had before
+func tagWithUndo(ctx context.Context, j *journal, backend Tagger, source, ref string) error {
+	previous, err := backend.Current(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if err := backend.Tag(ctx, source, ref); err != nil {
+		return err
+	}
+
+	if previous == "" {
+		j.record("removing "+ref, func(ctx context.Context) error {
+			return backend.Untag(ctx, ref)
+		})
+	} else if previous != source {
+		j.record(fmt.Sprintf("restoring %s to %s", ref, previous), func(ctx context.Context) error {
+			return backend.Tag(ctx, previous, ref)
+		})
+	}
+	return nil
+}
+
+// pushWithUndo pushes ref and records how to put the registry back the way it
+// was, the previous manifest is tagged again or the new tag is deleted
+func pushWithUndo(ctx context.Context, j *journal, registry *registryClient, backend Tagger, ref string) (string, error) {
+	target, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	previous, mediaType, _, err := registry.getManifest(ctx, target)
+	if err != nil && !isNotFound(err) {
+		return "", fmt.Errorf("looking up %s before pushing: %v", ref, err)
+	}
+
+	digest, err := backend.Push(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	if previous == nil {
+		j.record("deleting pushed "+ref, func(ctx context.Context) error {
+			return registry.deleteManifest(ctx, target, target.Reference())
+		})
+	} else if digestOf(previous) != digest {
+		j.record("restoring pushed "+ref, func(ctx context.Context) error {
+			_, err := registry.putManifest(ctx, target, target.Reference(), previous, mediaType)
+			return err
+		})
+	}
+	return digest, nil
+}
@@ -0,0 +1,133 @@
+package aquarium
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// GitSignature is the outcome of verifying the signature of the release tag,
+// or of the commit when the tag is not signed
+type GitSignature struct {
+	// Object is what was verified, "tag v1.2.3" or "commit <hash>"
+	Object string
+	// Format is gpg or ssh
+	Format string
+	// Signer is the user id of a gpg key or the principal of an ssh key
+	Signer string
+	// Key is the fingerprint of the signing key
+	Key string
+	// Verified is only true for a good signature made by a trusted key
+	Verified bool
+	// Unsigned is true when the object carries no signature at all
+	Unsigned bool
+	// Error explains why the signature was not verified
+	Error string
+}
+
+var (
+	sshGoodSig = regexp.MustCompile(`Good "git" signature(?: for (\S+))? with \S+ key (\S+)`)
+	gpgGoodSig = regexp.MustCompile(`(?m)^\[GNUPG:\] GOODSIG \S+ (.*)$`)
+	gpgValid   = regexp.MustCompile(`(?m)^\[GNUPG:\] VALIDSIG (\S+)(?: \S+){8} (\S+)`)
+)
+
+// Verify checks the signature of the annotated tag pointing at the commit of
+// data, falling back to the commit itself, against the trusted keys and
+// records the outcome as data.Signature
+func (c *Collector) Verify(ctx context.Context, data *Metadata, trust SignatureConfig) *GitSignature {
+	var sig *GitSignature
+	if data.Tag != nil && data.Tag.Annotated && contains(data.Commit.Tags, data.Tag.Name) {
+		sig = c.verify(ctx, trust, "tag "+data.Tag.Name, "verify-tag", data.Tag.Name)
+	}
+	if sig == nil || sig.Unsigned {
+		sig = c.verify(ctx, trust, "commit "+data.Commit.LongHash, "verify-commit", data.Commit.LongHash)
+	}
+	data.Signature = sig
+	return sig
+}
+
+func (c *Collector) verify(ctx context.Context, trust SignatureConfig, object, command, name string) *GitSignature {
+	// without allowed_signers no ssh key is trusted, whatever the git config
+	// of the machine allows
+	allowed := firstNonEmpty(trust.AllowedSigners, os.DevNull)
+	args := []string{"-c", "gpg.ssh.allowedSignersFile=" + allowed, command, "--raw", name}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = c.Dir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	output := out.String()
+
+	sig := &GitSignature{Object: object}
+	switch {
+	case gpgGoodSig.MatchString(output):
+		sig.Format = "gpg"
+		sig.Signer = gpgGoodSig.FindStringSubmatch(output)[1]
+		if m := gpgValid.FindStringSubmatch(output); m != nil {
+			sig.Key = m[1]
+			sig.Verified = err == nil && (trustedGPGKey(trust.GPGKeys, m[1]) || trustedGPGKey(trust.GPGKeys, m[2]))
+		}
+		if !sig.Verified {
+			sig.Error = fmt.Sprintf("%s is signed by %s (%s), which is not one of gpg_keys", object, sig.Signer, sig.Key)
+		}
+	case sshGoodSig.MatchString(output):
+		m := sshGoodSig.FindStringSubmatch(output)
+		sig.Format, sig.Signer, sig.Key = "ssh", m[1], m[2]
+		sig.Verified = err == nil && sig.Signer != ""
+		if !sig.Verified {
+			sig.Error = fmt.Sprintf("%s is signed by ssh key %s, which is not in allowed_signers", object, sig.Key)
+		}
+	case strings.TrimSpace(output) == "" || strings.Contains(output, "no signature found"):
+		sig.Unsigned = true
+		sig.Error = object + " is not signed"
+	case err == nil:
+		sig.Error = fmt.Sprintf("%s: unrecognized signature", object)
+	default:
+		sig.Error = fmt.Sprintf("%s: %s", object, firstNonEmpty(lastLine(output), err.Error()))
+	}
+	return sig
+}
+
+// trustedGPGKey matches a fingerprint against the configured keys, which may
+// also be given as long key ids
+func trustedGPGKey(keys []string, fingerprint string) bool {
+	fingerprint = strings.ToUpper(fingerprint)
+	for _, key := range keys {
+		key = strings.ToUpper(strings.Replace(key, " ", "", -1))
+		if len(key) >= 16 && strings.HasSuffix(fingerprint, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// unsignedTags returns the tags that require a trusted signature when the
+// release was not signed by a trusted key, they are left out while every
+// other tag is still applied
+func unsignedTags(tags []string, patterns []string, sig *GitSignature) ([]string, error) {
+	required, err := newTagPatterns("require_signed", patterns)
+	if err != nil {
+		return nil, err
+	}
+	if sig != nil && sig.Verified {
+		return nil, nil
+	}
+
+	var blocked []string
+	for _, tag := range tags {
+		if required.match(tag) {
+			blocked = append(blocked, tag)
+		}
+	}
+	return blocked, nil
+}
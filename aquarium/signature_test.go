@@ -0,0 +1,69 @@
+package aquarium
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestVerifyIgnoresMachineSSHTrust signs a commit with an ssh key the git
+// config of the machine trusts, which must not count unless allowed_signers
+// lists it too
+func TestVerifyIgnoresMachineSSHTrust(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	dir, err := ioutil.TempDir("", "aquarium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "alice", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	public, err := ioutil.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := filepath.Join(dir, "allowed_signers")
+	if err := ioutil.WriteFile(allowed, append([]byte("alice@example.com "), public...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(dir, "repo")
+	if err := os.Mkdir(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repo, "init", "-q")
+	sign := exec.Command("git", "-c", "gpg.format=ssh", "-c", "user.signingkey="+key, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-S", "--allow-empty", "-m", "signed")
+	sign.Dir = repo
+	if out, err := sign.CombinedOutput(); err != nil {
+		t.Skipf("git cannot sign with ssh keys: %s", out)
+	}
+	hash := runGit(t, repo, "rev-parse", "HEAD")
+
+	// the machine trusts the key through its global git config
+	home := filepath.Join(dir, "home")
+	if err := os.Mkdir(home, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[gpg \"ssh\"]\n\tallowedSignersFile = "+allowed+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	c := &Collector{Dir: repo}
+	data := &Metadata{Commit: &GitCommit{LongHash: hash}}
+	if sig := c.Verify(context.Background(), data, SignatureConfig{GPGKeys: []string{"0123456789ABCDEF"}}); sig.Verified || sig.Format != "ssh" {
+		t.Errorf("got %+v, want an untrusted ssh signature", sig)
+	}
+	if sig := c.Verify(context.Background(), data, SignatureConfig{AllowedSigners: allowed}); !sig.Verified || sig.Signer != "alice@example.com" {
+		t.Errorf("got %+v, want a signature verified for alice@example.com", sig)
+	}
+}
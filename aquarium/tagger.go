@@ -0,0 +1,112 @@
+package aquarium
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Tagger is something images can be tagged, labeled and pushed in
+type Tagger interface {
+	// Resolve turns the image to tag (the -imgID value) into the source
+	// to tag for image name
+	Resolve(ctx context.Context, source, name string) (string, error)
+	// Inspect returns the id of source and the registry digests known for it
+	Inspect(ctx context.Context, source string) (*ImageInfo, error)
+	// Label creates an image from source carrying labels and returns the
+	// source to tag from then on
+	Label(ctx context.Context, source string, labels map[string]string) (string, error)
+	// Unlabel removes what Label created for the source it returned again
+	Unlabel(ctx context.Context, labeled string) error
+	// Tag points ref (name:tag) at source
+	Tag(ctx context.Context, source, ref string) error
+	// Current returns the source ref points at, empty if it does not exist
+	Current(ctx context.Context, ref string) (string, error)
+	// Untag removes ref again
+	Untag(ctx context.Context, ref string) error
+	// Push makes sure ref is available in its registry and returns its digest
+	Push(ctx context.Context, ref string) (string, error)
+}
+
+// ImageInfo identifies an image inside its backend
+type ImageInfo struct {
+	ID      string
+	Digests []string
+}
+
+// the backend kinds a TaggerSet knows how to create
+const (
+	BackendDocker   = "docker"
+	BackendPodman   = "podman"
+	BackendRegistry = "registry"
+	BackendOCI      = "oci"
+	BackendArchive  = "archive"
+)
+
+// TaggerOptions holds the connection settings of the daemon backends and the
+// paths of the backends working on local files
+type TaggerOptions struct {
+	Docker        DockerOptions
+	OCILayout     string
+	Archive       string
+	ArchiveOutput string
+}
+
+// TaggerSet lazily creates each kind of backend once per run
+type TaggerSet struct {
+	options  TaggerOptions
+	backends map[string]Tagger
+}
+
+// NewTaggerSet returns an empty set, taggers are created on first use
+func NewTaggerSet(options TaggerOptions) *TaggerSet {
+	return &TaggerSet{
+		options:  options,
+		backends: map[string]Tagger{},
+	}
+}
+
+// Get returns the tagger of a backend kind, creating it when needed
+func (p *TaggerSet) Get(kind string) (Tagger, error) {
+	if b, ok := p.backends[kind]; ok {
+		return b, nil
+	}
+
+	var (
+		b   Tagger
+		err error
+	)
+	switch kind {
+	case BackendDocker:
+		b, err = NewDockerTagger(p.options.Docker)
+	case BackendPodman:
+		b, err = NewPodmanTagger()
+	case BackendRegistry:
+		b, err = NewRegistryTagger()
+	case BackendOCI:
+		b, err = NewOCITagger(p.options.OCILayout)
+	case BackendArchive:
+		b, err = NewArchiveTagger(p.options.Archive, p.options.ArchiveOutput)
+	default:
+		return nil, fmt.Errorf("unknown backend %q, allowed values: [%s, %s, %s, %s, %s]", kind, BackendDocker, BackendPodman, BackendRegistry, BackendOCI, BackendArchive)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.backends[kind] = b
+	return b, nil
+}
+
+// Close finishes the backends that only write their result at the end of a
+// run, like the archive backend
+func (p *TaggerSet) Close() error {
+	for _, b := range p.backends {
+		if c, ok := b.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package aquarium
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Var is an entry of the vars section of .aquarium.yml, exactly one of the
+// sources is set
+type Var struct {
+	// Value is a literal
+	Value string `yaml:"value"`
+	// Env is the name of an environment variable
+	Env string `yaml:"env"`
+	// File is read whole, or looked up with Path when it is JSON or YAML
+	File string `yaml:"file"`
+	// Path selects a value of File, like .version or $.dependencies[0].name
+	Path string `yaml:"path"`
+	// Command is run by sh, its trimmed stdout is the value
+	Command string `yaml:"command"`
+}
+
+// resolveVars computes every variable, reporting all failures at once
+func (c *Collector) resolveVars(ctx context.Context) (map[string]string, error) {
+	names := make([]string, 0, len(c.Vars))
+	for name := range c.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vars := make(map[string]string, len(c.Vars))
+	errs := make([]error, len(names))
+	for i, name := range names {
+		value, err := c.resolveVar(ctx, c.Vars[name])
+		if err != nil {
+			errs[i] = fmt.Errorf("vars.%s: %v", name, err)
+			continue
+		}
+		vars[name] = value
+	}
+	if err := collectErrors(errs); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func (c *Collector) resolveVar(ctx context.Context, v Var) (string, error) {
+	sources := 0
+	for _, s := range []string{v.Value, v.Env, v.File, v.Command} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return "", errors.New("needs exactly one of value, env, file or command")
+	}
+	if v.Path != "" && v.File == "" {
+		return "", errors.New("path only applies to file")
+	}
+
+	switch {
+	case v.Env != "":
+		value, ok := os.LookupEnv(v.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", v.Env)
+		}
+		return value, nil
+	case v.File != "":
+		return c.readVarFile(v.File, v.Path)
+	case v.Command != "":
+		cmd := exec.CommandContext(ctx, "sh", "-c", v.Command)
+		cmd.Dir = c.Dir
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("%s: %s", v.Command, firstNonEmpty(strings.TrimSpace(stderr.String()), err.Error()))
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+	return v.Value, nil
+}
+
+// readVarFile returns the trimmed content of a file, or the value at path
+// when a path is given
+func (c *Collector) readVarFile(file, path string) (string, error) {
+	if !filepath.IsAbs(file) && c.Dir != "" {
+		file = filepath.Join(c.Dir, file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	var doc interface{}
+	if filepath.Ext(file) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&doc)
+	} else {
+		doc, err = decodeYAML(data)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", file, err)
+	}
+
+	value, err := lookupPath(doc, path)
+	if err != nil {
+		return "", fmt.Errorf("%s in %s: %v", path, file, err)
+	}
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case map[string]interface{}, []interface{}:
+		out, err := json.Marshal(value)
+		return string(out), err
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+var pathSegment = regexp.MustCompile(`^\[(\d+)\]|^\.?([^.\[]+)`)
+
+// lookupPath follows a path like $.a.b[0].c (the $ is optional) into a
+// document decoded from JSON or YAML
+func lookupPath(doc interface{}, path string) (interface{}, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	for rest != "" && rest != "." {
+		m := pathSegment.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("invalid path at %q", rest)
+		}
+		rest = rest[len(m[0]):]
+
+		if m[1] != "" {
+			list, ok := doc.([]interface{})
+			i, _ := strconv.Atoi(m[1])
+			if !ok || i >= len(list) {
+				return nil, fmt.Errorf("no element %s", m[0])
+			}
+			doc = list[i]
+			continue
+		}
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("no key %s", m[2])
+		}
+		if doc, ok = object[m[2]]; !ok {
+			return nil, fmt.Errorf("no key %s", m[2])
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,383 @@
+package aquarium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// version is a tag parsed by one of the schemes, it fills the parts into the
+// GitTag and orders itself against other tags
+type version interface {
+	fill(t *GitTag)
+	// compare is negative when v is lower than other, ok is false when other
+	// follows another scheme and can't be compared
+	compare(other version) (cmp int, ok bool)
+}
+
+// parseVersion reads the version out of a tag name, a configured pattern
+// goes first and CalVer before semver since 2026.10.1 is both. It returns nil
+// for tags following no scheme
+func (c *Collector) parseVersion(name string) version {
+	if c.TagPattern != nil {
+		if v := c.TagPattern.parse(name); v != nil {
+			return v
+		}
+	}
+	raw := strings.TrimPrefix(name, "v")
+	if c.CalVer != nil {
+		if v, err := c.CalVer.Parse(raw); err == nil {
+			return v
+		}
+	}
+	if v, err := semver.Make(raw); err == nil {
+		return semVersion(v)
+	}
+	return nil
+}
+
+// isLatest tells if no tag in the repository is a higher version of the same
+// scheme, floating tags like latest or 1.2 should only move for those. It
+// fails rather than guess when the clone is missing tags of origin.
+func (c *Collector) isLatest(ctx context.Context, v version) (bool, error) {
+	if !c.tagsComplete(ctx) {
+		return false, errors.New("cannot tell whether the tag is the latest release without every tag of origin")
+	}
+	out, err := c.git(ctx, "tag", "--list")
+	if err != nil {
+		return false, err
+	}
+	for _, name := range strings.Fields(out) {
+		other := c.parseVersion(name)
+		if other == nil {
+			continue
+		}
+		if cmp, ok := v.compare(other); ok && cmp < 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// tagsComplete tells if the clone has every tag of origin. Only shallow clones
+// and clones made with --no-tags are checked, against ls-remote, and count as
+// incomplete when origin can't be asked.
+func (c *Collector) tagsComplete(ctx context.Context) bool {
+	shallow, _ := c.git(ctx, "rev-parse", "--is-shallow-repository")
+	tagOpt, _ := c.git(ctx, "config", "--get", "remote.origin.tagOpt")
+	if strings.TrimSpace(shallow) != "true" && strings.TrimSpace(tagOpt) != "--no-tags" {
+		return true
+	}
+
+	remote, err := c.git(ctx, "ls-remote", "--tags", "--refs", "origin")
+	if err != nil {
+		return false
+	}
+	local, err := c.git(ctx, "tag", "--list")
+	if err != nil {
+		return false
+	}
+	have := map[string]bool{}
+	for _, name := range strings.Fields(local) {
+		have[name] = true
+	}
+	for _, line := range strings.Split(remote, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && !have[strings.TrimPrefix(fields[1], "refs/tags/")] {
+			return false
+		}
+	}
+	return true
+}
+
+type semVersion semver.Version
+
+// fill sets the numeric parts, pre-release and build metadata are only in Raw
+func (v semVersion) fill(t *GitTag) {
+	t.Major = fmt.Sprint(v.Major)
+	t.Minor = fmt.Sprint(v.Minor)
+	t.Patch = fmt.Sprint(v.Patch)
+	t.SemVer = true
+}
+
+func (v semVersion) compare(other version) (int, bool) {
+	o, ok := other.(semVersion)
+	if !ok {
+		return 0, false
+	}
+	return semver.Version(v).Compare(semver.Version(o)), true
+}
+
+// calverTokens are the parts of a scheme as named on https://calver.org,
+// longest first so YYYY isn't read as YY twice
+var calverTokens = []struct {
+	name    string
+	pattern string
+}{
+	{"YYYY", `[1-9][0-9]{3}`},
+	{"MAJOR", `0|[1-9][0-9]*`},
+	{"MINOR", `0|[1-9][0-9]*`},
+	{"MICRO", `0|[1-9][0-9]*`},
+	{"YY", `[0-9]|[1-9][0-9]{1,2}`},
+	{"0Y", `[0-9]{2,3}`},
+	{"MM", `[1-9]|1[0-2]`},
+	{"0M", `0[1-9]|1[0-2]`},
+	{"WW", `[0-9]|[1-4][0-9]|5[0-3]`},
+	{"0W", `[0-4][0-9]|5[0-3]`},
+	{"DD", `[1-9]|[12][0-9]|3[01]`},
+	{"0D", `0[1-9]|[12][0-9]|3[01]`},
+}
+
+// CalVerScheme is a calendar versioning scheme like YYYY.MM.MICRO or YY.0M
+type CalVerScheme struct {
+	format string
+	tokens []string
+	re     *regexp.Regexp
+}
+
+// ParseCalVerScheme compiles a scheme of calver.org tokens (YYYY, YY, 0Y, MM,
+// 0M, WW, 0W, DD, 0D, MAJOR, MINOR, MICRO) separated by ".", "-" or "_"
+func ParseCalVerScheme(format string) (*CalVerScheme, error) {
+	s := &CalVerScheme{format: format}
+	expr := "^"
+	year := false
+	for rest := format; rest != ""; {
+		matched := false
+		for _, token := range calverTokens {
+			if strings.HasPrefix(rest, token.name) {
+				s.tokens = append(s.tokens, token.name)
+				expr += "(" + token.pattern + ")"
+				rest = rest[len(token.name):]
+				year = year || strings.HasSuffix(token.name, "Y")
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if !strings.ContainsAny(rest[:1], ".-_") {
+			return nil, fmt.Errorf("calver scheme %q: unknown token at %q", format, rest)
+		}
+		expr += regexp.QuoteMeta(rest[:1])
+		rest = rest[1:]
+	}
+	if !year {
+		return nil, fmt.Errorf("calver scheme %q has no year", format)
+	}
+	// an optional modifier, 2026.10.1-rc1
+	s.re = regexp.MustCompile(expr + `(?:-([0-9A-Za-z.-]+))?$`)
+	return s, nil
+}
+
+func (s *CalVerScheme) String() string {
+	return s.format
+}
+
+// CalVer is a version of a CalVerScheme, parts not in the scheme are zero
+type CalVer struct {
+	Year, Month, Week, Day int
+	Major, Minor, Micro    int
+	Modifier               string
+
+	scheme *CalVerScheme
+}
+
+// Parse reads a version following the scheme. YY and 0Y are short years of
+// this century as on calver.org, 6 and 06 are 2006, so YY.MM.MICRO reads a
+// semver tag like 1.2.3 as a 2001 release
+func (s *CalVerScheme) Parse(raw string) (*CalVer, error) {
+	m := s.re.FindStringSubmatch(raw)
+	if m == nil {
+		return nil, fmt.Errorf("%q does not follow calver scheme %s", raw, s.format)
+	}
+	v := &CalVer{scheme: s, Modifier: m[len(m)-1]}
+	for i, token := range s.tokens {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return nil, err
+		}
+		*v.part(token) = n
+	}
+	if v.Year < 1000 {
+		v.Year += 2000
+	}
+	return v, nil
+}
+
+func (v *CalVer) part(token string) *int {
+	switch token {
+	case "YYYY", "YY", "0Y":
+		return &v.Year
+	case "MM", "0M":
+		return &v.Month
+	case "WW", "0W":
+		return &v.Week
+	case "DD", "0D":
+		return &v.Day
+	case "MAJOR":
+		return &v.Major
+	case "MINOR":
+		return &v.Minor
+	}
+	return &v.Micro
+}
+
+// Compare orders versions of the same scheme part by part, like semver
+// pre-releases one with a modifier is lower than the one without
+func (v *CalVer) Compare(o *CalVer) int {
+	for _, token := range v.scheme.tokens {
+		if a, b := *v.part(token), *o.part(token); a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.Modifier == o.Modifier:
+		return 0
+	case v.Modifier == "":
+		return 1
+	case o.Modifier == "":
+		return -1
+	}
+	return strings.Compare(v.Modifier, o.Modifier)
+}
+
+func (v *CalVer) fill(t *GitTag) {
+	t.CalVer = true
+	t.Modifier = v.Modifier
+	for _, token := range v.scheme.tokens {
+		n := fmt.Sprint(*v.part(token))
+		switch token {
+		case "YYYY", "YY", "0Y":
+			t.Year = n
+		case "MM", "0M":
+			t.Month = n
+		case "WW", "0W":
+			t.Week = n
+		case "DD", "0D":
+			t.Day = n
+		case "MAJOR":
+			t.Major = n
+		case "MINOR":
+			t.Minor = n
+		case "MICRO":
+			t.Micro = n
+		}
+	}
+}
+
+func (v *CalVer) compare(other version) (int, bool) {
+	o, ok := other.(*CalVer)
+	if !ok || o.scheme != v.scheme {
+		return 0, false
+	}
+	return v.Compare(o), true
+}
+
+// TagPattern parses tags with a regular expression, like release-42 or
+// app_1.2-final
+type TagPattern struct {
+	re    *regexp.Regexp
+	order []int
+}
+
+// NewTagPattern compiles expr, which must match the whole tag name and have
+// named groups. Versions are ordered by the groups in order, numerically when
+// both values are numbers
+func NewTagPattern(expr string, order []string) (*TagPattern, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("tag_pattern %q: %v", expr, err)
+	}
+	p := &TagPattern{re: re}
+	groups := map[string]int{}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			groups[name] = i
+			if len(order) == 0 {
+				p.order = append(p.order, i)
+			}
+		}
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("tag_pattern %q has no named groups", expr)
+	}
+	for _, name := range order {
+		i, ok := groups[name]
+		if !ok {
+			return nil, fmt.Errorf("tag_pattern order: %q is not a group of %q", name, expr)
+		}
+		p.order = append(p.order, i)
+	}
+	return p, nil
+}
+
+type patternVersion struct {
+	pattern *TagPattern
+	match   []string
+}
+
+func (p *TagPattern) parse(name string) version {
+	match := p.re.FindStringSubmatch(name)
+	if match == nil {
+		return nil
+	}
+	return &patternVersion{pattern: p, match: match}
+}
+
+func (v *patternVersion) fill(t *GitTag) {
+	t.Fields = map[string]string{}
+	for i, name := range v.pattern.re.SubexpNames() {
+		if name != "" {
+			t.Fields[name] = v.match[i]
+		}
+	}
+}
+
+func (v *patternVersion) compare(other version) (int, bool) {
+	o, ok := other.(*patternVersion)
+	if !ok || o.pattern != v.pattern {
+		return 0, false
+	}
+	for _, i := range v.pattern.order {
+		if cmp := compareField(v.match[i], o.match[i]); cmp != 0 {
+			return cmp, true
+		}
+	}
+	return 0, true
+}
+
+// compareField compares numbers of any length by value and anything else as
+// strings
+func compareField(a, b string) int {
+	if isDigits(a) && isDigits(b) {
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
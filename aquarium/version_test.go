@@ -0,0 +1,219 @@
+package aquarium
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCalVerScheme(t *testing.T) {
+	tests := []struct {
+		scheme, raw string
+		want        CalVer
+	}{
+		{"YYYY.MM.MICRO", "2026.10.1", CalVer{Year: 2026, Month: 10, Micro: 1}},
+		{"YYYY.MM.MICRO", "2026.3.0", CalVer{Year: 2026, Month: 3}},
+		{"YYYY.0M.MICRO", "2026.03.12", CalVer{Year: 2026, Month: 3, Micro: 12}},
+		{"YY.0M", "26.03", CalVer{Year: 2026, Month: 3}},
+		{"YY.0M", "6.11", CalVer{Year: 2006, Month: 11}},
+		{"YY.0M", "106.01", CalVer{Year: 2106, Month: 1}},
+		{"0Y.0M", "06.01", CalVer{Year: 2006, Month: 1}},
+		{"YY.MM.MICRO", "1.2.3", CalVer{Year: 2001, Month: 2, Micro: 3}},
+		{"YYYY.0W", "2026.07", CalVer{Year: 2026, Week: 7}},
+		{"YYYY-0M-0D", "2026-10-18", CalVer{Year: 2026, Month: 10, Day: 18}},
+		{"YYYY.MINOR.MICRO", "2026.4.2", CalVer{Year: 2026, Minor: 4, Micro: 2}},
+		{"MAJOR.YY.MICRO", "3.26.1", CalVer{Year: 2026, Major: 3, Micro: 1}},
+		{"YYYY.MM.MICRO", "2026.10.1-rc1", CalVer{Year: 2026, Month: 10, Micro: 1, Modifier: "rc1"}},
+		{"YY.0M", "26.03-beta.2", CalVer{Year: 2026, Month: 3, Modifier: "beta.2"}},
+	}
+	for _, tt := range tests {
+		s, err := ParseCalVerScheme(tt.scheme)
+		if err != nil {
+			t.Fatalf("ParseCalVerScheme(%q): %v", tt.scheme, err)
+		}
+		got, err := s.Parse(tt.raw)
+		if err != nil {
+			t.Errorf("%s: Parse(%q): %v", tt.scheme, tt.raw, err)
+			continue
+		}
+		got.scheme = nil
+		if *got != tt.want {
+			t.Errorf("%s: Parse(%q) = %+v, want %+v", tt.scheme, tt.raw, *got, tt.want)
+		}
+	}
+}
+
+func TestCalVerSchemeRejects(t *testing.T) {
+	tests := []struct {
+		scheme, raw string
+	}{
+		{"YYYY.0M", "2026.3"},
+		{"YYYY.0M", "2026.13"},
+		{"YYYY.MM", "2026.03"},
+		{"YYYY.MM", "26.3"},
+		{"YY.0M", "06.01"},
+		{"YYYY.MM.MICRO", "2026.10.01"},
+		{"YYYY.MM.MICRO", "2026.10"},
+		{"YYYY.MM.MICRO", "v2026.10.1"},
+		{"YYYY.MM.MICRO", "2026.10.1-"},
+	}
+	for _, tt := range tests {
+		s, err := ParseCalVerScheme(tt.scheme)
+		if err != nil {
+			t.Fatalf("ParseCalVerScheme(%q): %v", tt.scheme, err)
+		}
+		if v, err := s.Parse(tt.raw); err == nil {
+			t.Errorf("%s: Parse(%q) = %+v, want an error", tt.scheme, tt.raw, *v)
+		}
+	}
+}
+
+func TestParseCalVerSchemeErrors(t *testing.T) {
+	for _, scheme := range []string{"", "MM.MICRO", "YYYY/MM", "YYYY.MONTH"} {
+		if _, err := ParseCalVerScheme(scheme); err == nil {
+			t.Errorf("ParseCalVerScheme(%q) succeeded, want an error", scheme)
+		}
+	}
+}
+
+func TestCalVerCompare(t *testing.T) {
+	tests := []struct {
+		scheme, a, b string
+		want         int
+	}{
+		{"YYYY.MM.MICRO", "2026.10.1", "2026.10.1", 0},
+		{"YYYY.MM.MICRO", "2026.9.0", "2026.10.0", -1},
+		{"YYYY.MM.MICRO", "2027.1.0", "2026.12.9", 1},
+		{"YYYY.MM.MICRO", "2026.10.2", "2026.10.10", -1},
+		{"YY.0M", "99.12", "106.01", -1},
+		{"YY.0M", "6.01", "26.01", -1},
+		{"YYYY.MM.MICRO", "2026.10.1-rc1", "2026.10.1", -1},
+		{"YYYY.MM.MICRO", "2026.10.1", "2026.10.1-rc1", 1},
+		{"YYYY.MM.MICRO", "2026.10.1-rc1", "2026.10.1-rc2", -1},
+		{"YYYY.MM.MICRO", "2026.10.1-rc1", "2026.10.0", 1},
+	}
+	for _, tt := range tests {
+		s, err := ParseCalVerScheme(tt.scheme)
+		if err != nil {
+			t.Fatalf("ParseCalVerScheme(%q): %v", tt.scheme, err)
+		}
+		a, err := s.Parse(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := s.Parse(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s: %s compared to %s = %d, want %d", tt.scheme, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCalVerCompareAcrossSchemes(t *testing.T) {
+	a, _ := ParseCalVerScheme("YYYY.MM.MICRO")
+	b, _ := ParseCalVerScheme("YYYY.MM.MICRO")
+	va, err := a.Parse("2026.10.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vb, err := b.Parse("2026.10.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := va.compare(vb); ok {
+		t.Error("versions of different schemes were compared")
+	}
+	if _, ok := va.compare(semVersion{Major: 2026, Minor: 10, Patch: 2}); ok {
+		t.Error("a CalVer was compared to a semver")
+	}
+}
+
+func TestCompareField(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"9", "10", -1},
+		{"10", "9", 1},
+		{"007", "7", 0},
+		{"010", "9", 1},
+		{"123456789012345678901234567890", "99", 1},
+		{"final", "rc", -1},
+		{"rc10", "rc9", -1},
+		{"10", "beta", -1},
+		{"", "0", -1},
+		{"same", "same", 0},
+	}
+	for _, tt := range tests {
+		if got := compareField(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareField(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestTagPatternCompare(t *testing.T) {
+	tests := []struct {
+		expr  string
+		order []string
+		a, b  string
+		want  int
+	}{
+		{`release-(?P<build>\d+)`, nil, "release-9", "release-10", -1},
+		{`(?P<app>[a-z]+)_(?P<n>\d+)`, nil, "api_9", "web_1", -1},
+		{`(?P<app>[a-z]+)_(?P<n>\d+)`, []string{"n"}, "api_9", "web_1", 1},
+		{`(?P<app>[a-z]+)_(?P<n>\d+)`, []string{"n"}, "api_1", "web_1", 0},
+		{`(?P<major>\d+)\.(?P<minor>\d+)-(?P<stage>[a-z]+)`, []string{"major", "minor", "stage"}, "1.2-final", "1.2-rc", -1},
+		{`(?P<major>\d+)\.(?P<minor>\d+)-(?P<stage>[a-z]+)`, []string{"minor"}, "2.1-rc", "1.2-rc", -1},
+	}
+	for _, tt := range tests {
+		p, err := NewTagPattern(tt.expr, tt.order)
+		if err != nil {
+			t.Fatalf("NewTagPattern(%q, %v): %v", tt.expr, tt.order, err)
+		}
+		a, b := p.parse(tt.a), p.parse(tt.b)
+		if a == nil || b == nil {
+			t.Fatalf("%q does not match %s or %s", tt.expr, tt.a, tt.b)
+		}
+		if got, ok := a.compare(b); !ok || got != tt.want {
+			t.Errorf("%q ordered by %v: %s compared to %s = %d (%v), want %d", tt.expr, tt.order, tt.a, tt.b, got, ok, tt.want)
+		}
+	}
+}
+
+func TestTagPatternMatchesWholeName(t *testing.T) {
+	p, err := NewTagPattern(`release-(?P<build>\d+)`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"release-1x", "prerelease-1", "release-"} {
+		if p.parse(name) != nil {
+			t.Errorf("%s matched", name)
+		}
+	}
+
+	tag := &GitTag{}
+	p.parse("release-42").fill(tag)
+	if tag.Fields["build"] != "42" || len(tag.Fields) != 1 {
+		t.Errorf("fields = %v, want build 42", tag.Fields)
+	}
+}
+
+func TestNewTagPatternErrors(t *testing.T) {
+	tests := []struct {
+		expr  string
+		order []string
+		err   string
+	}{
+		{`release-(\d+)`, nil, `tag_pattern "release-(\\d+)" has no named groups`},
+		{`release-\d+`, []string{"build"}, `tag_pattern "release-\\d+" has no named groups`},
+		{`release-(?P<build>\d+)`, []string{"major"}, `tag_pattern order: "major" is not a group of "release-(?P<build>\\d+)"`},
+		{`release-(?P<build>\d+`, nil, `tag_pattern "release-(?P<build>\\d+": error parsing regexp: `},
+	}
+	for _, tt := range tests {
+		_, err := NewTagPattern(tt.expr, tt.order)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("NewTagPattern(%q, %v) = %v, want %s", tt.expr, tt.order, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/srizzling/aquarium/aquarium"
+)
+
+// bakeFile is the subset of the docker buildx bake JSON format export writes
+type bakeFile struct {
+	Target map[string]bakeTarget `json:"target"`
+}
+
+type bakeTarget struct {
+	Tags   []string          `json:"tags,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Args   map[string]string `json:"args,omitempty"`
+}
+
+// export prints the rendered build_args (and for bake the tags and labels) so
+// docker build can be fed without duplicating the git logic in CI scripts
+func export(config *aquarium.Config, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "args", "What to print allowed values: [args (--build-arg flags), env (an env file), bake (a docker-bake.json)]")
+	target := fs.String("target", "default", "The name of the docker-bake.json target")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: aquarium [flags] export [export flags]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		exitWithError(err)
+	}
+
+	tmplData, err := newCollector(config).Collect(context.Background())
+	if err != nil {
+		exitWithError(err)
+	}
+	buildArgs, err := aquarium.BuildArgs(tmplData, config.BuildArgs)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	switch *format {
+	case "args":
+		flags := make([]string, 0, len(buildArgs))
+		for _, key := range sortedKeys(buildArgs) {
+			flags = append(flags, "--build-arg "+shellQuote(key+"="+buildArgs[key]))
+		}
+		fmt.Println(strings.Join(flags, " "))
+	case "env":
+		for _, key := range sortedKeys(buildArgs) {
+			if strings.Contains(buildArgs[key], "\n") {
+				exitWithError(fmt.Errorf("build arg %s spans several lines, which an env file cannot hold", key))
+			}
+			fmt.Printf("%s=%s\n", key, buildArgs[key])
+		}
+	case "bake":
+		bake, err := bakeTargetFor(config, tmplData, buildArgs)
+		if err != nil {
+			exitWithError(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(bakeFile{Target: map[string]bakeTarget{*target: *bake}}); err != nil {
+			exitWithError(err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown export format %q\n\n", *format)
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// bakeTargetFor fills a bake target with every tag and label a run would apply
+func bakeTargetFor(config *aquarium.Config, tmplData *aquarium.Metadata, buildArgs map[string]string) (*bakeTarget, error) {
+	labels, err := aquarium.Labels(tmplData, config.LabelFormat, config.OCILabels)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &bakeTarget{Labels: labels, Args: buildArgs}
+	for _, nameTemplate := range config.ImageNames {
+		name, err := aquarium.RenderImageName(nameTemplate, tmplData)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := aquarium.RenderTags(name, tmplData, config.TagFormat)
+		if err != nil {
+			return nil, err
+		}
+		target.Tags = append(target.Tags, refs...)
+	}
+	return target, nil
+}
+
+var shellSafe = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// shellQuote quotes s for a POSIX shell when it needs to be
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -1,57 +1,35 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"sort"
 	"strings"
 
-	"github.com/alecthomas/template"
-	"github.com/blang/semver"
-	"github.com/docker/docker/client"
+	"github.com/srizzling/aquarium/aquarium"
 	"github.com/srizzling/aquarium/version"
-	yaml "gopkg.in/yaml.v1"
 )
 
-type gitBranch struct {
-	Name string
-}
-
-type gitCommit struct {
-	ShortHash string
-	LongHash  string
-}
-
-type gitTag struct {
-	Major  string
-	Minor  string
-	Patch  string
-	Raw    string
-	SemVer bool
-}
-
-type aqTemplate struct {
-	Tag    *gitTag
-	Commit *gitCommit
-	Branch *gitBranch
-}
-
-type aqConfig struct {
-	TagFormat   []string `yaml:"tag_format"`
-	LabelFormat []string `yaml:"label_format"`
-	ImageNames  []string `yaml:"image_names"`
-}
-
 var (
 	versionFlag  bool
 	outputFormat string
 	imgID        string
+	refFlag      string
+	manifestPath string
+	backendFlag  string
+	ociLayout    string
+	archive      string
+	archiveOut   string
+	pushFlag     bool
+	dockerOpts   aquarium.DockerOptions
+
+	concurrencyFlag int
+	bestEffortFlag  bool
+	forceFlag       bool
 )
 
 const banner = `
@@ -63,14 +41,32 @@ GitCommitSHA: %s
 
 func init() {
 	flag.StringVar(&imgID, "imgID", "", "The Id of the image to tag")
+	flag.StringVar(&refFlag, "ref", "", "The commit, tag or branch to compute the git metadata for (default HEAD)")
 	flag.StringVar(&outputFormat, "output", "json", "The formatting style for the command output allowed values: [json, text]")
+	flag.StringVar(&manifestPath, "manifest", "", "Write a JSON manifest describing the tagged images to this path")
+	flag.StringVar(&backendFlag, "backend", "", "Where the image lives, overrides the backend set in the config allowed values: [docker, podman, registry, oci, archive]")
+	flag.StringVar(&ociLayout, "oci-layout", "", "The OCI image layout directory used by the oci backend, overrides oci_layout in the config")
+	flag.StringVar(&archive, "archive", "", "The docker save tarball used by the archive backend, overrides archive in the config")
+	flag.StringVar(&archiveOut, "archive-output", "", "Where the archive backend writes the retagged tarball, defaults to rewriting the input")
+	flag.StringVar(&dockerOpts.Host, "host", "", "The docker daemon to connect to, defaults to DOCKER_HOST")
+	flag.StringVar(&dockerOpts.TLSCACert, "tls-cacert", "", "Trust certs signed only by this CA, defaults to $DOCKER_CERT_PATH/ca.pem")
+	flag.StringVar(&dockerOpts.TLSCert, "tls-cert", "", "Path to the TLS client certificate, defaults to $DOCKER_CERT_PATH/cert.pem")
+	flag.StringVar(&dockerOpts.TLSKey, "tls-key", "", "Path to the TLS client key, defaults to $DOCKER_CERT_PATH/key.pem")
+	flag.BoolVar(&dockerOpts.TLSVerify, "tls-verify", false, "Use TLS and verify the daemon certificate, defaults to DOCKER_TLS_VERIFY")
+	flag.BoolVar(&pushFlag, "push", false, "Push every applied tag to its registry")
+	flag.IntVar(&concurrencyFlag, "concurrency", 0, "How many tag and push operations run at once, overrides concurrency in the config (default 4)")
+	flag.BoolVar(&forceFlag, "force", false, "Move tags matching immutable_tags even if they already point at another image")
+	flag.BoolVar(&bestEffortFlag, "best-effort", false, "Keep the tags that were applied when others fail instead of rolling the whole run back")
 	flag.BoolVar(&versionFlag, "v", false, "print version and exit")
 
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, fmt.Sprintf(banner, version.Version, version.GitCommitSHA))
+		fmt.Fprint(os.Stderr, "Usage: aquarium [flags] [command]\n\nCommands:\n  promote\tcopy the staging image of a release to the production registry\n  render\tprint what the templates evaluate to, without tagging anything\n  export\tprint the build args, tags and labels for docker build or buildx bake\n\nFlags:\n")
 		flag.PrintDefaults()
 	}
+}
 
+func main() {
 	flag.Parse()
 
 	if versionFlag {
@@ -78,188 +74,221 @@ func init() {
 		os.Exit(0)
 	}
 
-	if imgID == "" {
+	if imgID == "" && flag.NArg() == 0 {
 		usageAndExit("Image id cannot be empty", 1)
 	}
 
 	if outputFormat != "json" && outputFormat != "text" {
 		usageAndExit("OutputFormat not accepte	d", 1)
 	}
-}
 
-func main() {
-	config := aqConfig{}
+	// render can evaluate an ad-hoc template without any config
 	data, err := ioutil.ReadFile(".aquarium.yml")
-	if err != nil {
-		panic(err)
+	if err != nil && !(os.IsNotExist(err) && flag.Arg(0) == "render") {
+		exitWithError(err)
 	}
 
-	err = yaml.Unmarshal(data, &config)
+	config, err := aquarium.ParseConfig(data)
 	if err != nil {
-		panic(err)
+		exitWithError(err)
 	}
 
-	tmplData, err := getGitInfo()
+	switch flag.Arg(0) {
+	case "":
+		tagImage(config, data)
+	case "promote":
+		promote(config, data, flag.Args()[1:])
+	case "render":
+		render(config, flag.Args()[1:])
+	case "export":
+		export(config, flag.Args()[1:])
+	default:
+		usageAndExit(fmt.Sprintf("Unknown command %q", flag.Arg(0)), 1)
+	}
+}
+
+// tagImage applies the rendered tags to the -imgID image for every image name
+func tagImage(config *aquarium.Config, data []byte) {
+	ctx := context.Background()
+	collector := newCollector(config)
+	tmplData, err := collector.Collect(ctx)
 	if err != nil {
-		panic(err)
+		exitWithError(err)
+	}
+	if config.Signatures.Enabled() {
+		collector.Verify(ctx, tmplData, config.Signatures)
 	}
 
-	docker, err := client.NewEnvClient()
+	labels, err := aquarium.Labels(tmplData, config.LabelFormat, config.OCILabels)
 	if err != nil {
-		panic(err)
+		exitWithError(err)
 	}
 
-	var taggedImgs []string
-	for _, name := range config.ImageNames {
-		dockerTags, err := setTag(name, tmplData, config.TagFormat, docker)
+	manifest := aquarium.NewManifest(data, tmplData, labels)
+
+	taggers := aquarium.NewTaggerSet(aquarium.TaggerOptions{
+		Docker:        dockerOpts,
+		OCILayout:     firstNonEmpty(ociLayout, config.OCILayout),
+		Archive:       firstNonEmpty(archive, config.Archive),
+		ArchiveOutput: firstNonEmpty(archiveOut, config.ArchiveOutput),
+	})
+
+	images := make([]aquarium.Image, len(config.ImageNames))
+	for i, nameTemplate := range config.ImageNames {
+		name, err := aquarium.RenderImageName(nameTemplate, tmplData)
+		if err != nil {
+			exitWithError(err)
+		}
+		tagger, err := taggers.Get(config.BackendFor(nameTemplate, backendFlag))
 		if err != nil {
-			panic(err)
+			exitWithError(err)
 		}
-		taggedImgs = append(taggedImgs, dockerTags...)
+		images[i] = aquarium.Image{Name: name, Tagger: tagger}
 	}
 
-	printImgs(taggedImgs)
-}
+	opts := aquarium.ApplyOptions{
+		Source:        imgID,
+		TagFormats:    config.TagFormat,
+		Push:          pushFlag,
+		Force:         forceFlag,
+		ImmutableTags: config.ImmutableTags,
+		RequireSigned: config.Signatures.RequireSigned,
+		BestEffort:    bestEffortFlag || config.BestEffort,
+		Concurrency:   concurrencyFlag,
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = config.Concurrency
+	}
+	if config.ApplyLabels {
+		opts.Labels = labels
+	}
 
-func printImgs(taggedImgs []string) {
-	if outputFormat == "text" {
-		for _, img := range taggedImgs {
-			fmt.Printf("%s\n", img)
-		}
-	} else if outputFormat == "json" {
-		var jsonReturn = struct {
-			Images []string `json:"images"`
-		}{
-			taggedImgs,
-		}
+	// a best effort run still records and reports the tags that made it
+	results, applyErr := aquarium.Apply(ctx, tmplData, images, opts)
+	if results == nil {
+		exitWithError(applyErr)
+	}
 
-		json, err := json.Marshal(jsonReturn)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Printf("%s", json)
+	var taggedImgs, blocked []string
+	for _, res := range results {
+		taggedImgs = append(taggedImgs, res.Tags...)
+		blocked = append(blocked, res.Blocked...)
 	}
-}
 
-func setTag(name string, tmplData *aqTemplate, tagFormats []string, docker *client.Client) (images []string, err error) {
-	for _, tagTemplate := range tagFormats {
-		t := template.Must(template.New("tag_template").Parse(tagTemplate))
-		buf := new(bytes.Buffer)
-		err := t.Execute(buf, tmplData)
-		if err != nil {
-			return nil, err
+	if manifestPath != "" {
+		for _, res := range results {
+			if err := manifest.AddImage(ctx, res.Name, res.Source, res.Tags, res.Digests, res.Tagger); err != nil {
+				exitWithError(err)
+			}
 		}
+	}
 
-		imgName := fmt.Sprintf("%s:%s", name, buf.String())
-		err = docker.ImageTag(context.Background(), imgID, imgName)
-		if err != nil {
-			return nil, err
-		}
-		images = append(images, imgName)
+	if err := taggers.Close(); err != nil {
+		exitWithError(err)
 	}
-	return images, nil
 
-}
+	if manifestPath != "" {
+		if err := manifest.Write(manifestPath); err != nil {
+			exitWithError(err)
+		}
+	}
 
-func runGit(args ...string) (string, error) {
-	var cmd = exec.Command("git", args...)
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return "", errors.New(stderr.String())
+	printImgs(taggedImgs, blocked, labels, tmplData.Signature)
+	if applyErr != nil {
+		exitWithError(applyErr)
 	}
-	return stdout.String(), nil
 }
 
-func getGitInfo() (*aqTemplate, error) {
-	tag, err := getTag()
+// newCollector reads the git metadata of the current directory the way the
+// config and flags ask for
+func newCollector(config *aquarium.Config) *aquarium.Collector {
+	loc, err := config.Location()
 	if err != nil {
-		return nil, err
+		exitWithError(err)
 	}
-
-	commit, err := getCommit()
+	calver, err := config.CalVerScheme()
 	if err != nil {
-		return nil, err
+		exitWithError(err)
 	}
-
-	branch, err := getBranch()
+	pattern, err := config.TagPattern.Compile()
 	if err != nil {
-		return nil, err
+		exitWithError(err)
 	}
-
-	gitTmpl := &aqTemplate{
-		Tag:    tag,
-		Branch: branch,
-		Commit: commit,
+	return &aquarium.Collector{
+		Ref:          refFlag,
+		FetchMissing: config.FetchMissing,
+		Vars:         config.Vars,
+		Location:     loc,
+		CalVer:       calver,
+		TagPattern:   pattern,
+		Reproducible: config.Reproducible,
 	}
-
-	return gitTmpl, nil
 }
 
-// getTag tries to imitate `git describe --tags` command to retreive the tag on the HEAD
-func getTag() (*gitTag, error) {
-	raw, err := runGit("describe", "--tags", "--abbrev=0")
-	if err != nil {
-		return nil, err
+// printImgs prints the applied tags, the ones blocked for lack of a trusted
+// signature are reported on stderr as well
+func printImgs(taggedImgs, blocked []string, labels map[string]string, signature *aquarium.GitSignature) {
+	if len(blocked) > 0 {
+		reason := "the signature was not verified"
+		if signature != nil && signature.Error != "" {
+			reason = signature.Error
+		}
+		fmt.Fprintf(os.Stderr, "aquarium: not applying %s, they require a trusted signature: %s\n", strings.Join(blocked, ", "), reason)
 	}
-	tag := strings.TrimSpace(raw)
 
-	// Check if tag is semver compliant
-	// does the tag start with v? strip it
-	tag = strings.TrimPrefix(tag, "v")
+	if outputFormat == "text" {
+		for _, img := range taggedImgs {
+			fmt.Printf("%s\n", img)
+		}
+	} else if outputFormat == "json" {
+		var jsonReturn = struct {
+			Images    []string               `json:"images"`
+			Blocked   []string               `json:"blocked,omitempty"`
+			Labels    map[string]string      `json:"labels,omitempty"`
+			Signature *aquarium.GitSignature `json:"signature,omitempty"`
+		}{
+			taggedImgs,
+			blocked,
+			labels,
+			signature,
+		}
 
-	v, err := semver.Make(tag)
-	if err != nil {
-		// well the tag isn't semver compliant.. so lets just return the raw value
-		return &gitTag{
-			Raw:    tag,
-			SemVer: false,
-		}, nil
+		json, err := json.Marshal(jsonReturn)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf("%s", json)
 	}
-
-	// unfourently git describe doesn't return a semver compliant tag
-	// so lets just move it to build information
-	return &gitTag{
-		Major:  fmt.Sprint(v.Major),
-		Minor:  fmt.Sprint(v.Minor),
-		Patch:  fmt.Sprint(v.Patch),
-		Raw:    tag,
-		SemVer: true,
-	}, nil
 }
 
-func getCommit() (*gitCommit, error) {
-	longHash, err := runGit("rev-parse", "HEAD")
-	if err != nil {
-		return nil, err
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
 	}
+	return ""
+}
 
-	shortHash, err := runGit("rev-parse", "--short", "HEAD")
-	if err != nil {
-		return nil, err
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-
-	return &gitCommit{
-		LongHash:  strings.TrimSpace(longHash),
-		ShortHash: strings.TrimSpace(shortHash),
-	}, nil
+	sort.Strings(keys)
+	return keys
 }
 
-func getBranch() (*gitBranch, error) {
-	name, err := runGit("rev-parse", "--abbrev-ref", "HEAD")
-	if err != nil {
-		return nil, err
-	}
-	return &gitBranch{
-		Name: strings.TrimSpace(name),
-	}, nil
+// exitWithError reports a failed run without a stack trace, the errors are
+// meant to be read by whoever runs aquarium
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "aquarium: %v\n", err)
+	os.Exit(1)
 }
 
 func usageAndExit(message string, exitCode int) {
 	if message != "" {
-		fmt.Fprintf(os.Stderr, message)
+		fmt.Fprint(os.Stderr, message)
 		fmt.Fprintf(os.Stderr, "\n\n")
 	}
 	flag.Usage()
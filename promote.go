@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/srizzling/aquarium/aquarium"
+)
+
+// promote copies the staging image built from HEAD (or -ref) to the production
+// repository and applies the release tags there. Only commits a git tag
+// points at are promoted.
+func promote(config *aquarium.Config, data []byte, args []string) {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	from := fs.String("from", config.Promote.From, "The staging repository to promote from")
+	to := fs.String("to", config.Promote.To, "The repository to promote to")
+	commitTag := fs.String("commit-tag", firstNonEmpty(config.Promote.CommitTag, aquarium.DefaultCommitTag), "The template of the tag the staging image carries")
+	buildManifest := fs.String("build-manifest", "", "Find the staging image by digest in a manifest written by -manifest instead of by commit tag")
+	untagged := fs.Bool("allow-untagged", false, "Promote even if no git tag points at HEAD")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: aquarium [flags] promote [promote flags]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		exitWithError(err)
+	}
+
+	if *from == "" || *to == "" {
+		fmt.Fprint(os.Stderr, "promote needs a staging (-from) and target (-to) repository\n\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	collector := newCollector(config)
+	tmplData, err := collector.Collect(ctx)
+	if err != nil {
+		exitWithError(err)
+	}
+	if config.Signatures.Enabled() {
+		collector.Verify(ctx, tmplData, config.Signatures)
+	}
+	if !*untagged && len(tmplData.Commit.Tags) == 0 {
+		exitWithError(fmt.Errorf("%s is not tagged, only releases are promoted (see -allow-untagged)", firstNonEmpty(refFlag, "HEAD")))
+	}
+
+	// the repositories are image names and rendered the same way
+	if *from, err = aquarium.RenderImageName(*from, tmplData); err != nil {
+		exitWithError(err)
+	}
+	if *to, err = aquarium.RenderImageName(*to, tmplData); err != nil {
+		exitWithError(err)
+	}
+
+	src, err := aquarium.StagingImage(*from, *commitTag, *buildManifest, tmplData)
+	if err != nil {
+		exitWithError(err)
+	}
+	dst, err := aquarium.ParseReference(*to)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	tagFormats := config.Promote.TagFormat
+	if len(tagFormats) == 0 {
+		tagFormats = config.TagFormat
+	}
+
+	var tags []string
+	for _, tagTemplate := range tagFormats {
+		tag, err := aquarium.RenderTag(tagTemplate, tmplData)
+		if err != nil {
+			exitWithError(err)
+		} else if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	result, err := aquarium.Promote(ctx, src, dst, aquarium.PromoteOptions{
+		Tags:          tags,
+		Force:         forceFlag,
+		ImmutableTags: config.ImmutableTags,
+		RequireSigned: config.Signatures.RequireSigned,
+		Signature:     tmplData.Signature,
+	})
+	if err != nil {
+		exitWithError(err)
+	}
+
+	var promoted, blocked []string
+	for _, tag := range result.Tags {
+		promoted = append(promoted, fmt.Sprintf("%s:%s", *to, tag))
+	}
+	for _, tag := range result.Blocked {
+		blocked = append(blocked, fmt.Sprintf("%s:%s", *to, tag))
+	}
+
+	if manifestPath != "" && result.Digest != "" {
+		manifest := aquarium.NewManifest(data, tmplData, nil)
+		manifest.Record(*to, result.Digest, promoted, []string{*to + "@" + result.Digest})
+		if err := manifest.Write(manifestPath); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	printImgs(promoted, blocked, nil, tmplData.Signature)
+}
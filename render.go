@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/srizzling/aquarium/aquarium"
+)
+
+// rendered is the outcome of evaluating one template
+type rendered struct {
+	Source   string `json:"source"`
+	Template string `json:"template"`
+	Result   string `json:"result,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// render evaluates an ad-hoc template or every configured template against the
+// repository or a fixture and prints the results, without touching any image
+func render(config *aquarium.Config, args []string) {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	text := fs.String("template", "", "Render this template instead of the configured ones")
+	fixture := fs.String("fixture", "", "Render against the metadata in this JSON or YAML file instead of the repository")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: aquarium [flags] render [render flags]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		exitWithError(err)
+	}
+
+	var (
+		tmplData *aquarium.Metadata
+		err      error
+	)
+	if *fixture != "" {
+		tmplData, err = aquarium.ReadMetadata(*fixture)
+	} else {
+		tmplData, err = newCollector(config).Collect(context.Background())
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+
+	var results []rendered
+	if *text != "" {
+		results = append(results, evaluate("template", *text, func() (string, error) {
+			return aquarium.Render("template", *text, tmplData)
+		}))
+	} else {
+		results = renderConfig(config, tmplData)
+	}
+
+	failed := false
+	for _, r := range results {
+		failed = failed || r.Error != ""
+	}
+
+	if outputFormat == "text" {
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Printf("%s: %s\n  error: %s\n", r.Source, r.Template, r.Error)
+			} else {
+				fmt.Printf("%s: %s\n  %s\n", r.Source, r.Template, strings.Replace(firstNonEmpty(r.Result, "(empty)"), "\n", "\n  ", -1))
+			}
+		}
+	} else {
+		out, err := json.Marshal(results)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf("%s", out)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// renderConfig evaluates every template of the config the way a run would
+func renderConfig(config *aquarium.Config, tmplData *aquarium.Metadata) []rendered {
+	var results []rendered
+	for i, t := range config.ImageNames {
+		results = append(results, evaluate(fmt.Sprintf("image_names[%d]", i), t, func() (string, error) {
+			return aquarium.RenderImageName(t, tmplData)
+		}))
+	}
+	for i, t := range config.TagFormat {
+		results = append(results, evaluate(fmt.Sprintf("tag_format[%d]", i), t, func() (string, error) {
+			return aquarium.RenderTag(t, tmplData)
+		}))
+	}
+	for i, t := range config.LabelFormat {
+		results = append(results, evaluate(fmt.Sprintf("label_format[%d]", i), t, func() (string, error) {
+			return renderLabels(tmplData, []string{t}, false)
+		}))
+	}
+	for i, t := range config.BuildArgs {
+		results = append(results, evaluate(fmt.Sprintf("build_args[%d]", i), t, func() (string, error) {
+			args, err := aquarium.BuildArgs(tmplData, []string{t})
+			if err != nil {
+				return "", err
+			}
+			for key, value := range args {
+				return key + "=" + value, nil
+			}
+			return "", nil
+		}))
+	}
+	if config.OCILabels {
+		results = append(results, evaluate("oci_labels", "", func() (string, error) {
+			return renderLabels(tmplData, nil, true)
+		}))
+	}
+	if config.Promote.CommitTag != "" {
+		t := config.Promote.CommitTag
+		results = append(results, evaluate("promote.commit_tag", t, func() (string, error) {
+			return aquarium.RenderTag(t, tmplData)
+		}))
+	}
+	for i, t := range config.Promote.TagFormat {
+		results = append(results, evaluate(fmt.Sprintf("promote.tag_format[%d]", i), t, func() (string, error) {
+			return aquarium.RenderTag(t, tmplData)
+		}))
+	}
+	return results
+}
+
+func evaluate(source, text string, fn func() (string, error)) rendered {
+	r := rendered{Source: source, Template: text}
+	result, err := fn()
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.Result = result
+	}
+	return r
+}
+
+// renderLabels renders labels as key=value lines
+func renderLabels(tmplData *aquarium.Metadata, labelFormats []string, withOCI bool) (string, error) {
+	labels, err := aquarium.Labels(tmplData, labelFormats, withOCI)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, 0, len(labels))
+	for _, key := range sortedKeys(labels) {
+		lines = append(lines, key+"="+labels[key])
+	}
+	return strings.Join(lines, "\n"), nil
+}